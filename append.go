@@ -0,0 +1,231 @@
+package s3kv
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/trace"
+)
+
+// segMagic marks a value which is stored as an index of segments. It is followed by the JSON-encoded segIndex.
+var segMagic = []byte("S3KS")
+
+// segIndex lists the segments of a value written by Append in segmented mode.
+type segIndex struct {
+	Sizes []int `json:"sizes"` // The size of each segment, in order.
+}
+
+// segNamespace is appended to the store's namespace to form the namespace for segments, which keeps them out of
+// List results.
+const segNamespace = ".segments"
+
+// segKey returns the backing key for a segment of the given key.
+func (s *Store) segKey(key string, i int) string {
+	return fmt.Sprintf("%s%s%s%s%s%08d", s.namespace, segNamespace, NS_DELIM, key, NS_DELIM, i)
+}
+
+// segKeys returns the backing keys for every segment in an index.
+func (s *Store) segKeys(key string, idx segIndex) []Key {
+	keys := make([]Key, len(idx.Sizes))
+	for i := range idx.Sizes {
+		keys[i] = s.segKey(key, i)
+	}
+	return keys
+}
+
+// parseIndex returns the segment index in a stored value, or false if the value is not segmented.
+func parseIndex(key string, stored []byte) (segIndex, bool, error) {
+	if !bytes.HasPrefix(stored, segMagic) {
+		return segIndex{}, false, nil
+	}
+	idx := segIndex{}
+	if err := json.Unmarshal(stored[len(segMagic):], &idx); err != nil {
+		return segIndex{}, true, fmt.Errorf("reading segment index for %s: %w", key, err)
+	}
+	return idx, true, nil
+}
+
+// encodeIndex returns the stored form of a segment index.
+func encodeIndex(idx segIndex) []byte {
+	data, _ := json.Marshal(idx)
+	return append(append([]byte{}, segMagic...), data...)
+}
+
+// decode returns the value a caller sees for the given stored bytes: nil if it has expired, and reassembled from its
+// segments if it was written in segmented mode.
+func (s *Store) decode(op *operation, key string, stored []byte) ([]byte, error) {
+	idx, segmented, err := parseIndex(key, stored)
+	if err != nil || !segmented {
+		value, _ := decodeValue(stored, time.Now())
+		return value, err
+	}
+	var segments map[Key][]byte
+	err = op.call("GetMany", trace.String(trace.AttrKey, key), func() (err error) {
+		segments, err = backing.GetMany(s.backing, s.segKeys(key, idx))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	value := []byte{}
+	for i, size := range idx.Sizes {
+		// the last segment is rewritten before the index, so it may already hold data from an append in progress
+		segment, ok := segments[s.segKey(key, i)]
+		if !ok || len(segment) < size {
+			return nil, fmt.Errorf("segment %d of %s is missing or truncated", i, key)
+		}
+		value = append(value, segment[:size]...)
+	}
+	return value, nil
+}
+
+// Append appends data to the value for the given key, creating it if needed. You must have an open session for the
+// key.
+//
+// By default the whole value is rewritten, keeping its TTL if it has one. If Args.AppendSegmentSize is set, a value
+// which outgrows it is split into segment objects plus a small index, so each append only rewrites the last
+// segment and the index. Get reassembles segmented values transparently. Segmented values don't expire.
+func (s *Store) Append(sid SessionID, key string, data []byte) error {
+	op := s.begin("Append", key, sid, trace.Int(trace.AttrBytes, len(data)))
+	err := s.check(sid, key)
+	var stored []byte
+	if err == nil {
+		err = op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+			stored, err = s.backing.Get(s.ns1(key))
+			return err
+		})
+	}
+	if err == nil {
+		err = s.append(op, key, stored, data)
+	}
+	if err == nil {
+		err = s.recordAppend(op, key)
+	}
+	op.end(err)
+	return err
+}
+
+// append appends data to the given stored value.
+func (s *Store) append(op *operation, key string, stored []byte, data []byte) error {
+	idx, segmented, err := parseIndex(key, stored)
+	if err != nil {
+		return err
+	}
+	if segmented {
+		return s.appendSegment(op, key, idx, data)
+	}
+
+	value, expires := splitExpiry(stored)
+	if !expires.IsZero() && !time.Now().Before(expires) {
+		value, expires = nil, time.Time{}
+	}
+	if s.segmentSize > 0 && len(value)+len(data) > s.segmentSize {
+		idx := segIndex{}
+		if len(value) > 0 {
+			if err := s.setSegment(op, key, 0, value); err != nil {
+				return err
+			}
+			idx.Sizes = append(idx.Sizes, len(value))
+		}
+		return s.appendSegment(op, key, idx, data)
+	}
+
+	value = append(append([]byte{}, value...), data...)
+	if expires.IsZero() {
+		value = encodeValue(value)
+	} else {
+		value = withExpiry(value, expires)
+	}
+	return op.call("Set", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Set(s.ns1(key), value)
+	})
+}
+
+// appendSegment appends data to the last segment of a segmented value, or starts a new segment if the last one is
+// full, then writes the updated index.
+func (s *Store) appendSegment(op *operation, key string, idx segIndex, data []byte) error {
+	last := len(idx.Sizes) - 1
+	if last >= 0 && idx.Sizes[last]+len(data) <= s.segmentSize {
+		var segment []byte
+		err := op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+			segment, err = s.backing.Get(s.segKey(key, last))
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if len(segment) < idx.Sizes[last] {
+			return fmt.Errorf("segment %d of %s is missing or truncated", last, key)
+		}
+		segment = segment[:idx.Sizes[last]] // drop data from an append which failed to update the index
+		if err := s.setSegment(op, key, last, append(segment, data...)); err != nil {
+			return err
+		}
+		idx.Sizes[last] += len(data)
+	} else {
+		if err := s.setSegment(op, key, last+1, data); err != nil {
+			return err
+		}
+		idx.Sizes = append(idx.Sizes, len(data))
+	}
+	return op.call("Set", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Set(s.ns1(key), encodeIndex(idx))
+	})
+}
+
+// setSegment writes a single segment of the given key.
+func (s *Store) setSegment(op *operation, key string, i int, data []byte) error {
+	return op.call("Set", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Set(s.segKey(key, i), data)
+	})
+}
+
+// replace writes a key through fn and then deletes any segments the key had before, so that Set and Del don't leave
+// orphaned segments behind. Segments only exist if Args.AppendSegmentSize is set, so otherwise this just calls fn.
+func (s *Store) replace(op *operation, key string, fn func() error) error {
+	if s.segmentSize <= 0 {
+		return fn()
+	}
+	var stored []byte
+	err := op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		stored, err = s.backing.Get(s.ns1(key))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	idx, segmented, err := parseIndex(key, stored)
+	if err != nil {
+		return err
+	}
+	if err := fn(); err != nil || !segmented {
+		return err
+	}
+	return op.call("DelMany", trace.String(trace.AttrKey, key), func() error {
+		return backing.DelMany(s.backing, s.segKeys(key, idx))
+	})
+}
+
+// recordAppend records the full value of a key in its history after an append. It only reads the value back if the
+// store keeps an emulated history.
+func (s *Store) recordAppend(op *operation, key string) error {
+	if s.nativeVersions || s.historyArgs == nil {
+		return nil
+	}
+	var stored []byte
+	err := op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		stored, err = s.backing.Get(s.ns1(key))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	value, err := s.decode(op, key, stored)
+	if err != nil {
+		return err
+	}
+	return s.record(op, key, value)
+}
@@ -0,0 +1,110 @@
+package s3kv_test
+
+import (
+	"strings"
+	"time"
+
+	"github.com/mplewis/s3kv"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("append", func() {
+	It("appends to whole values", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "log", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.Append(sid, "k", []byte("a"))).To(Succeed())
+		Expect(s.Append(sid, "k", []byte("bc"))).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte("abc")))
+		Expect(s.Append("nope", "k", []byte("d"))).To(MatchError(ContainSubstring("does not include key")))
+	})
+
+	It("splits large values into segments and reassembles them", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "log", Backing: b, AppendSegmentSize: 4})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.Set(sid, "k", []byte("ab"))).To(Succeed())
+		for _, chunk := range []string{"c", "de", "fgh", "i"} {
+			Expect(s.Append(sid, "k", []byte(chunk))).To(Succeed())
+		}
+		Expect(s.Get("k")).To(Equal([]byte("abcdefghi")))
+		Expect(s.List("")).To(Equal([]string{"log/k"}))
+
+		b.Lock()
+		Expect(b.Data).To(HaveKeyWithValue("log.segments/k/00000000", []byte("abc")))
+		Expect(b.Data).To(HaveKeyWithValue("log.segments/k/00000001", []byte("de")))
+		Expect(b.Data).To(HaveKeyWithValue("log.segments/k/00000002", []byte("fghi")))
+		delete(b.Data, "log.segments/k/00000001")
+		b.Unlock()
+		_, err = s.Get("k")
+		Expect(err).To(MatchError(ContainSubstring("segment 1 of k is missing")))
+
+		Expect(s.Set(sid, "k", []byte("fresh"))).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte("fresh")))
+		Expect(b.Len()).To(Equal(1))
+
+		Expect(s.Append(sid, "k", []byte(strings.Repeat("x", 10)))).To(Succeed())
+		Expect(s.Del(sid, "k")).To(Succeed())
+		Expect(b.Len()).To(BeZero())
+	})
+
+	It("reads segments rewritten by an append which didn't update the index", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "log", Backing: b, AppendSegmentSize: 4})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.Append(sid, "k", []byte("abcd"))).To(Succeed())
+		Expect(s.Append(sid, "k", []byte("e"))).To(Succeed())
+
+		// an append rewrote the last segment, then failed before writing the index
+		b.Lock()
+		b.Data["log.segments/k/00000001"] = []byte("ef")
+		b.Unlock()
+		Expect(s.Get("k")).To(Equal([]byte("abcde")))
+		Expect(s.Append(sid, "k", []byte("g"))).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte("abcdeg")))
+	})
+
+	It("drops segments when a value is replaced with a TTL", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "log", Backing: b, AppendSegmentSize: 4})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.Append(sid, "k", []byte(strings.Repeat("x", 10)))).To(Succeed())
+		Expect(b.Len()).To(BeNumerically(">", 1))
+
+		Expect(s.SetWithTTL(sid, "k", []byte("v"), time.Hour)).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte("v")))
+		Expect(b.Len()).To(Equal(1))
+	})
+
+	It("keeps values which look like segment indexes unchanged", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "log", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.Set(sid, "k", []byte(`S3KS{"sizes":[1]}`))).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte(`S3KS{"sizes":[1]}`)))
+	})
+})
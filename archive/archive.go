@@ -0,0 +1,212 @@
+// Package archive exports the contents of a backing to a portable tar archive and restores it into any other
+// backing. Each value is a tar entry named after its key, with its metadata and a SHA-256 checksum in PAX records.
+//
+// Archives are written and read one value at a time, so a dataset can be larger than memory as long as each value
+// fits. Keys are exported in sorted order, so an interrupted export can be resumed into a second archive with
+// ExportArgs.After, and an interrupted import can be rerun with ImportArgs.SkipExisting.
+//
+// To archive everything a Store keeps for a namespace, including segments, sidecars and snapshots, export the
+// namespace name without a trailing delimiter as the prefix.
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+)
+
+// PAX record names used in archive entries.
+const (
+	recordSHA256       = "S3KV.sha256"
+	recordContentType  = "S3KV.content_type"
+	recordCacheControl = "S3KV.cache_control"
+	recordUserPrefix   = "S3KV.meta."
+)
+
+// Progress describes how far an export or import has got.
+type Progress struct {
+	Key   string // The last key completed. Pass it as ExportArgs.After to resume an interrupted export.
+	Keys  int    // Keys completed so far.
+	Bytes int64  // Value bytes completed so far.
+}
+
+// add records a completed key.
+func (p *Progress) add(key string, size int) {
+	p.Key = key
+	p.Keys++
+	p.Bytes += int64(size)
+}
+
+// ExportArgs are the arguments for Export.
+type ExportArgs struct {
+	Prefix   string         // Optional. Only export keys with this prefix.
+	After    string         // Optional. Skip keys up to and including this one, to resume an interrupted export.
+	Progress func(Progress) // Optional. Called after each key is written.
+}
+
+// Export writes every key in the backing with the given prefix to w as a tar archive, and returns the progress made.
+// If it fails, the returned progress says which keys were written in full.
+func Export(w io.Writer, b backing.Backing, args ExportArgs) (Progress, error) {
+	p := Progress{}
+	keys, err := b.List(args.Prefix)
+	if err != nil {
+		return p, err
+	}
+	sort.Strings(keys)
+
+	tw := tar.NewWriter(w)
+	for _, key := range keys {
+		if args.After != "" && key <= args.After {
+			continue
+		}
+		value, err := b.Get(key)
+		if err != nil {
+			return p, fmt.Errorf("exporting %s: %w", key, err)
+		}
+		if value == nil {
+			continue
+		}
+		info, err := backing.Stat(b, key)
+		if err != nil && !errors.Is(err, backing.ErrUnsupported) {
+			return p, fmt.Errorf("exporting %s: %w", key, err)
+		}
+		if err := writeEntry(tw, key, value, info); err != nil {
+			return p, fmt.Errorf("exporting %s: %w", key, err)
+		}
+		p.add(key, len(value))
+		if args.Progress != nil {
+			args.Progress(p)
+		}
+	}
+	return p, tw.Close()
+}
+
+// writeEntry writes a single value to a tar archive.
+func writeEntry(tw *tar.Writer, key string, value []byte, info backing.Info) error {
+	sum := sha256.Sum256(value)
+	records := map[string]string{recordSHA256: hex.EncodeToString(sum[:])}
+	if info.Meta.ContentType != "" {
+		records[recordContentType] = info.Meta.ContentType
+	}
+	if info.Meta.CacheControl != "" {
+		records[recordCacheControl] = info.Meta.CacheControl
+	}
+	for name, v := range info.Meta.User {
+		records[recordUserPrefix+name] = v
+	}
+	modified := info.LastModified
+	if modified.IsZero() {
+		modified = time.Now()
+	}
+	err := tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeReg,
+		Name:       key,
+		Size:       int64(len(value)),
+		Mode:       0644,
+		ModTime:    modified,
+		PAXRecords: records,
+		Format:     tar.FormatPAX,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = tw.Write(value)
+	return err
+}
+
+// ImportArgs are the arguments for Import.
+type ImportArgs struct {
+	SkipExisting bool           // Optional. Skip keys whose current value already matches the archive, so an interrupted import can be rerun cheaply.
+	Progress     func(Progress) // Optional. Called after each key is restored or skipped.
+}
+
+// Import restores every value in a tar archive written by Export into the backing, and returns the progress made.
+// Each value is verified against its checksum before it is written, and a mismatch returns an error wrapping
+// backing.ErrChecksumMismatch. Metadata is restored if the backing implements backing.MetaWriter.
+func Import(r io.Reader, b backing.Backing, args ImportArgs) (Progress, error) {
+	p := Progress{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return p, nil
+		}
+		if err != nil {
+			return p, fmt.Errorf("reading archive after %d keys: %w", p.Keys, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		key := hdr.Name
+		value, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return p, fmt.Errorf("reading %s from archive: %w", key, err)
+		}
+		if err := verify(key, value, hdr.PAXRecords[recordSHA256]); err != nil {
+			return p, err
+		}
+		if err := restore(b, key, value, meta(hdr.PAXRecords), args.SkipExisting); err != nil {
+			return p, fmt.Errorf("importing %s: %w", key, err)
+		}
+		p.add(key, len(value))
+		if args.Progress != nil {
+			args.Progress(p)
+		}
+	}
+}
+
+// verify checks a value read from an archive against its recorded checksum.
+func verify(key string, value []byte, want string) error {
+	if want == "" {
+		return fmt.Errorf("%w: archive entry %s has no checksum", backing.ErrChecksumMismatch, key)
+	}
+	sum := sha256.Sum256(value)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("%w: archive entry %s has sha256 %s, expected %s", backing.ErrChecksumMismatch, key, got, want)
+	}
+	return nil
+}
+
+// meta returns the metadata recorded in an archive entry's PAX records.
+func meta(records map[string]string) backing.Meta {
+	m := backing.Meta{ContentType: records[recordContentType], CacheControl: records[recordCacheControl]}
+	for name, v := range records {
+		if strings.HasPrefix(name, recordUserPrefix) {
+			if m.User == nil {
+				m.User = map[string]string{}
+			}
+			m.User[strings.TrimPrefix(name, recordUserPrefix)] = v
+		}
+	}
+	return m
+}
+
+// restore writes a single value into the backing, with its metadata if it has any and the backing supports it.
+func restore(b backing.Backing, key string, value []byte, m backing.Meta, skipExisting bool) error {
+	if skipExisting {
+		current, err := b.Get(key)
+		if err != nil {
+			return err
+		}
+		if current != nil && bytes.Equal(current, value) {
+			return nil
+		}
+	}
+	if !m.IsZero() {
+		err := backing.SetWithMeta(b, key, value, m)
+		if !errors.Is(err, backing.ErrUnsupported) {
+			return err
+		}
+	}
+	return b.Set(key, value)
+}
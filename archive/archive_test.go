@@ -0,0 +1,129 @@
+package archive_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/mplewis/s3kv/archive"
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestArchive(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Archive Suite")
+}
+
+// metaMemory is an in-memory backing which stores metadata natively.
+type metaMemory struct {
+	*backingtest.Memory
+	meta map[string]backing.Meta
+	sets int
+}
+
+func newMetaMemory() *metaMemory {
+	return &metaMemory{Memory: backingtest.NewMemory(), meta: map[string]backing.Meta{}}
+}
+
+func (m *metaMemory) Set(key backing.Key, value []byte) error {
+	return m.SetWithMeta(key, value, backing.Meta{})
+}
+
+func (m *metaMemory) SetWithMeta(key backing.Key, value []byte, meta backing.Meta) error {
+	m.Lock()
+	m.sets++
+	m.meta[key] = meta
+	m.Unlock()
+	return m.Memory.Set(key, value)
+}
+
+func (m *metaMemory) Del(key backing.Key) error {
+	m.Lock()
+	delete(m.meta, key)
+	m.Unlock()
+	return m.Memory.Del(key)
+}
+
+func (m *metaMemory) Stat(key backing.Key) (backing.Info, error) {
+	m.Lock()
+	defer m.Unlock()
+	value, ok := m.Data[key]
+	if !ok {
+		return backing.Info{}, nil
+	}
+	return backing.Info{Exists: true, Size: int64(len(value)), Meta: m.meta[key]}, nil
+}
+
+var _ = Describe("archive", func() {
+	var src *metaMemory
+	BeforeEach(func() {
+		src = newMetaMemory()
+		Expect(src.Set("ns/a", []byte("alpha"))).To(Succeed())
+		Expect(src.SetWithMeta("ns/b", []byte(`{"b":1}`), backing.Meta{
+			ContentType: "application/json",
+			User:        map[string]string{"owner": "ops"},
+		})).To(Succeed())
+		Expect(src.Set("ns/c", []byte{})).To(Succeed())
+		Expect(src.Set("other/d", []byte("skipped"))).To(Succeed())
+	})
+
+	It("exports a prefix and imports it into another backing", func() {
+		buf := bytes.Buffer{}
+		var seen []string
+		p, err := archive.Export(&buf, src, archive.ExportArgs{
+			Prefix:   "ns/",
+			Progress: func(p archive.Progress) { seen = append(seen, p.Key) },
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p).To(Equal(archive.Progress{Key: "ns/c", Keys: 3, Bytes: 12}))
+		Expect(seen).To(Equal([]string{"ns/a", "ns/b", "ns/c"}))
+
+		dst := newMetaMemory()
+		p, err = archive.Import(&buf, dst, archive.ImportArgs{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Keys).To(Equal(3))
+		Expect(dst.Data).To(Equal(map[string][]byte{"ns/a": []byte("alpha"), "ns/b": []byte(`{"b":1}`), "ns/c": {}}))
+		Expect(dst.meta["ns/b"]).To(Equal(src.meta["ns/b"]))
+	})
+
+	It("resumes interrupted exports and imports", func() {
+		first := bytes.Buffer{}
+		_, err := archive.Export(&first, src, archive.ExportArgs{Prefix: "ns/"})
+		Expect(err).NotTo(HaveOccurred())
+		rest := bytes.Buffer{}
+		p, err := archive.Export(&rest, src, archive.ExportArgs{Prefix: "ns/", After: "ns/a"})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Keys).To(Equal(2))
+		resumed := newMetaMemory()
+		Expect(archive.Import(&rest, resumed, archive.ImportArgs{})).To(Equal(p))
+		Expect(resumed.List("")).To(Equal([]string{"ns/b", "ns/c"}))
+
+		dst := newMetaMemory()
+		truncated := bytes.NewReader(first.Bytes()[:first.Len()-1500])
+		p, err = archive.Import(truncated, dst, archive.ImportArgs{})
+		Expect(err).To(HaveOccurred())
+		Expect(p.Keys).To(BeNumerically("<", 3))
+
+		imported, sets := len(dst.Data), dst.sets
+		p, err = archive.Import(bytes.NewReader(first.Bytes()), dst, archive.ImportArgs{SkipExisting: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Keys).To(Equal(3))
+		Expect(dst.sets - sets).To(Equal(3 - imported))
+		Expect(dst.Data).To(HaveLen(3))
+	})
+
+	It("rejects corrupted archives", func() {
+		buf := bytes.Buffer{}
+		_, err := archive.Export(&buf, src, archive.ExportArgs{Prefix: "ns/a"})
+		Expect(err).NotTo(HaveOccurred())
+		corrupt := bytes.Replace(buf.Bytes(), []byte("alpha"), []byte("alphA"), 1)
+
+		dst := newMetaMemory()
+		_, err = archive.Import(bytes.NewReader(corrupt), dst, archive.ImportArgs{})
+		Expect(errors.Is(err, backing.ErrChecksumMismatch)).To(BeTrue())
+		Expect(dst.Data).To(BeEmpty())
+	})
+})
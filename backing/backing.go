@@ -7,7 +7,7 @@ type Key = string
 type Backing interface {
 	// List lists all keys in the store with the given prefix. This is likely a very slow operation, so use with caution.
 	List(prefix string) ([]Key, error)
-	// Get returns the value for the given key.
+	// Get returns the value for the given key, or nil if it does not exist.
 	Get(key Key) ([]byte, error)
 	// Set sets the value for the given key.
 	Set(key Key, value []byte) error
@@ -0,0 +1,25 @@
+package backing_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mplewis/s3kv/backing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestBacking(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Backing Suite")
+}
+
+var errBoom = errors.New("boom")
+
+// failing is a backing whose every operation fails.
+type failing struct{}
+
+func (failing) List(string) ([]backing.Key, error) { return nil, errBoom }
+func (failing) Get(backing.Key) ([]byte, error)    { return nil, errBoom }
+func (failing) Set(backing.Key, []byte) error      { return errBoom }
+func (failing) Del(backing.Key) error              { return errBoom }
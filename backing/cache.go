@@ -0,0 +1,263 @@
+package backing
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"time"
+)
+
+// CacheArgs are the arguments for a caching backing.
+type CacheArgs struct {
+	MaxBytes    int64         // Optional. The most key and value bytes to hold before evicting the least recently used. Defaults to 64 MiB.
+	TTL         time.Duration // Optional. How long a cached value is served without checking the backing. Zero means until evicted or invalidated.
+	NegativeTTL time.Duration // Optional. How long to remember that a key does not exist. Zero disables negative caching.
+	Revalidate  bool          // Optional. Once a value's TTL passes, compare ETags with a cheap Stat instead of fetching it again. Requires a Statter.
+}
+
+// Default values for CacheArgs, if unset.
+const defaultCacheMaxBytes = 64 << 20
+
+// cacheEntry is a cached value, or a cached absence of one.
+type cacheEntry struct {
+	key     Key
+	value   []byte
+	found   bool
+	etag    string
+	expires time.Time // zero means never
+}
+
+// size is the number of bytes the entry counts against the cache's limit.
+func (e *cacheEntry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+// cacheFill tracks the reads in progress which will fill the cache entry for a key.
+type cacheFill struct {
+	generation uint64 // incremented whenever the key is invalidated, so fills racing a write are discarded
+	readers    int
+}
+
+// caching is a Backing which serves reads from an in-memory LRU cache in front of another Backing.
+type caching struct {
+	next Backing
+	args CacheArgs
+	stat Statter // nil unless revalidating
+
+	access  sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[Key]*list.Element
+	bytes   int64
+	fills   map[Key]*cacheFill // only for keys with reads in progress
+}
+
+// Cache wraps a backing with a read-through cache. Values written or deleted through the cache are invalidated
+// immediately; writes made by other processes are seen once the TTL passes. Cached values are shared, so callers
+// must not modify the slices returned by Get.
+func Cache(b Backing, args CacheArgs) Backing {
+	if args.MaxBytes == 0 {
+		args.MaxBytes = defaultCacheMaxBytes
+	}
+	c := &caching{
+		next:    b,
+		args:    args,
+		lru:     list.New(),
+		entries: map[Key]*list.Element{},
+		fills:   map[Key]*cacheFill{},
+	}
+	if args.Revalidate && Supports(b, Stats) {
+		c.stat = b.(Statter)
+	}
+	return c
+}
+
+// CacheMiddleware returns middleware which wraps a backing with Cache.
+func CacheMiddleware(args CacheArgs) Middleware {
+	return func(next Backing) Backing {
+		return Cache(next, args)
+	}
+}
+
+// lookup returns the cached entry for a key and whether it is still fresh. The caller must hold c.access.
+func (c *caching) lookup(key Key) (*cacheEntry, bool) {
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	e := el.Value.(*cacheEntry)
+	return e, e.expires.IsZero() || time.Now().Before(e.expires)
+}
+
+// expiry returns when an entry cached now should expire.
+func (c *caching) expiry(found bool) time.Time {
+	ttl := c.args.TTL
+	if !found {
+		ttl = c.args.NegativeTTL
+	}
+	if ttl == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// startFill records a read in progress for a key and returns its fill. The caller must hold c.access.
+func (c *caching) startFill(key Key) *cacheFill {
+	f, ok := c.fills[key]
+	if !ok {
+		f = &cacheFill{}
+		c.fills[key] = f
+	}
+	f.readers++
+	return f
+}
+
+// endFill records that a read started with startFill is done.
+func (c *caching) endFill(key Key, f *cacheFill) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	if f.readers--; f.readers == 0 {
+		delete(c.fills, key)
+	}
+}
+
+// store caches an entry. The caller must hold c.access.
+func (c *caching) store(e *cacheEntry) {
+	if (!e.found && c.args.NegativeTTL == 0) || e.size() > c.args.MaxBytes {
+		return
+	}
+	c.remove(e.key)
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += e.size()
+	for c.bytes > c.args.MaxBytes {
+		c.remove(c.lru.Back().Value.(*cacheEntry).key)
+	}
+}
+
+// remove drops a key from the cache. The caller must hold c.access.
+func (c *caching) remove(key Key) {
+	if el, ok := c.entries[key]; ok {
+		c.bytes -= el.Value.(*cacheEntry).size()
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// invalidate drops keys from the cache and discards any fills in progress for them.
+func (c *caching) invalidate(keys ...Key) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	for _, key := range keys {
+		if f, ok := c.fills[key]; ok {
+			f.generation++
+		}
+		c.remove(key)
+	}
+}
+
+// Get returns the value for the given key, from the cache if possible.
+func (c *caching) Get(key Key) ([]byte, error) {
+	c.access.Lock()
+	e, fresh := c.lookup(key)
+	if e != nil && fresh {
+		c.access.Unlock()
+		return e.value, nil
+	}
+	f := c.startFill(key)
+	gen := f.generation
+	c.access.Unlock()
+	defer c.endFill(key, f)
+
+	var etag string
+	if e != nil && e.found && c.stat != nil {
+		info, err := c.stat.Stat(key)
+		if err != nil {
+			return nil, err
+		}
+		if info.Exists && info.ETag != "" && info.ETag == e.etag {
+			c.access.Lock()
+			if f.generation == gen {
+				e.expires = c.expiry(true)
+			}
+			c.access.Unlock()
+			return e.value, nil
+		}
+		etag = info.ETag
+	} else if c.stat != nil {
+		// Stat before reading, so if the value changes in between, the next revalidation sees a stale ETag
+		// and fetches it again rather than serving the old value forever.
+		info, err := c.stat.Stat(key)
+		if err != nil {
+			return nil, err
+		}
+		etag = info.ETag
+	}
+
+	value, err := c.next.Get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	c.access.Lock()
+	defer c.access.Unlock()
+	if f.generation == gen {
+		c.store(&cacheEntry{key: key, value: value, found: value != nil, etag: etag, expires: c.expiry(value != nil)})
+	}
+	return value, nil
+}
+
+// List lists all keys in the store with the given prefix. Listings are not cached.
+func (c *caching) List(prefix string) ([]Key, error) {
+	return c.next.List(prefix)
+}
+
+// Set sets the value for the given key and invalidates its cache entry.
+func (c *caching) Set(key Key, value []byte) error {
+	defer c.invalidate(key)
+	return c.next.Set(key, value)
+}
+
+// Del deletes the key-value pair for the given key and invalidates its cache entry.
+func (c *caching) Del(key Key) error {
+	defer c.invalidate(key)
+	return c.next.Del(key)
+}
+
+// GetStream returns a reader for the cached value of the given key.
+func (c *caching) GetStream(key Key) (io.ReadCloser, error) {
+	return GetStream(core{c}, key)
+}
+
+// SetStream sets the value for the given key from a reader and invalidates its cache entry.
+func (c *caching) SetStream(key Key, r io.Reader) error {
+	defer c.invalidate(key)
+	return SetStream(c.next, key, r)
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist, and invalidates its cache entry.
+func (c *caching) SetIfAbsent(key Key, value []byte) (bool, error) {
+	defer c.invalidate(key)
+	return SetIfAbsent(c.next, key, value)
+}
+
+// GetMany returns the cached values for the given keys.
+func (c *caching) GetMany(keys []Key) (map[Key][]byte, error) {
+	return GetMany(core{c}, keys)
+}
+
+// DelMany deletes the given keys and invalidates their cache entries.
+func (c *caching) DelMany(keys []Key) error {
+	defer c.invalidate(keys...)
+	return DelMany(c.next, keys)
+}
+
+// Stat returns information about the value for the given key from the next backing.
+func (c *caching) Stat(key Key) (Info, error) {
+	return Stat(c.next, key)
+}
+
+// Supports returns true if the next backing natively supports the given optional operation and the cache forwards
+// it without emulation.
+func (c *caching) Supports(op Capability) bool {
+	return (op == ConditionalWrites || op == Stats) && Supports(c.next, op)
+}
@@ -0,0 +1,166 @@
+package backing_test
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// versioned is a memory backing which supports Stat, with an ETag that changes on every write.
+type versioned struct {
+	*backingtest.Memory
+	writes int
+	etags  map[backing.Key]string
+}
+
+func newVersioned() *versioned {
+	return &versioned{Memory: backingtest.NewMemory(), etags: map[backing.Key]string{}}
+}
+
+func (v *versioned) Set(key backing.Key, value []byte) error {
+	v.writes++
+	v.etags[key] = fmt.Sprintf("etag-%d", v.writes)
+	return v.Memory.Set(key, value)
+}
+
+func (v *versioned) Stat(key backing.Key) (backing.Info, error) {
+	v.Record("stat " + key)
+	v.Lock()
+	defer v.Unlock()
+	value, ok := v.Data[key]
+	if !ok {
+		return backing.Info{}, nil
+	}
+	return backing.Info{Exists: true, Size: int64(len(value)), ETag: v.etags[key]}, nil
+}
+
+var _ = Describe("Cache", func() {
+	It("serves repeated reads from memory", func() {
+		m := backingtest.NewMemory()
+		m.Data["k"] = []byte("v")
+		c := backing.Cache(m, backing.CacheArgs{})
+
+		for i := 0; i < 3; i++ {
+			Expect(c.Get("k")).To(Equal([]byte("v")))
+		}
+		Expect(m.Calls()).To(Equal([]string{"get k"}))
+	})
+
+	It("invalidates on writes through the cache", func() {
+		m := backingtest.NewMemory()
+		c := backing.Cache(m, backing.CacheArgs{NegativeTTL: time.Hour})
+
+		Expect(c.Get("k")).To(BeNil())
+		Expect(c.Get("k")).To(BeNil())
+		Expect(c.Set("k", []byte("v1"))).To(Succeed())
+		Expect(c.Get("k")).To(Equal([]byte("v1")))
+		Expect(c.Get("k")).To(Equal([]byte("v1")))
+		Expect(c.Del("k")).To(Succeed())
+		Expect(c.Get("k")).To(BeNil())
+		Expect(backing.DelMany(c, []backing.Key{"k"})).To(Succeed())
+		Expect(c.Get("k")).To(BeNil())
+		Expect(m.Calls()).To(Equal([]string{"get k", "set k", "get k", "del k", "get k", "del k", "get k"}))
+	})
+
+	It("discards fills racing a write to the same key only", func() {
+		m := backingtest.NewMemory()
+		m.Data["a"] = []byte("v1")
+		m.Data["b"] = []byte("v1")
+		// the first read of each key pauses after reading, until released
+		entered, release := make(chan struct{}, 1), make(chan struct{})
+		var paused sync.Map
+		slow := backing.Chain(m, backing.Intercept(backing.Interceptor{
+			Get: func(key backing.Key, next func(backing.Key) ([]byte, error)) ([]byte, error) {
+				value, err := next(key)
+				if _, seen := paused.LoadOrStore(key, true); !seen {
+					entered <- struct{}{}
+					<-release
+				}
+				return value, err
+			},
+		}))
+		c := backing.Cache(slow, backing.CacheArgs{})
+		fill := func(key backing.Key) chan []byte {
+			done := make(chan []byte, 1)
+			go func() {
+				v, _ := c.Get(key)
+				done <- v
+			}()
+			<-entered
+			return done
+		}
+
+		done := fill("a")
+		Expect(c.Set("c", []byte("x"))).To(Succeed())
+		release <- struct{}{}
+		Expect(<-done).To(Equal([]byte("v1")))
+		Expect(c.Get("a")).To(Equal([]byte("v1")))
+		Expect(m.Calls()).To(Equal([]string{"get a", "set c"}))
+
+		done = fill("b")
+		Expect(c.Set("b", []byte("v2"))).To(Succeed())
+		release <- struct{}{}
+		Expect(<-done).To(Equal([]byte("v1")))
+		Expect(c.Get("b")).To(Equal([]byte("v2")))
+		Expect(m.Calls()).To(Equal([]string{"get b", "set b", "get b"}))
+	})
+
+	It("does not cache missing keys without a negative TTL", func() {
+		m := backingtest.NewMemory()
+		c := backing.Cache(m, backing.CacheArgs{})
+		Expect(c.Get("k")).To(BeNil())
+		Expect(c.Get("k")).To(BeNil())
+		Expect(m.Calls()).To(HaveLen(2))
+	})
+
+	It("expires entries after the TTL", func() {
+		m := backingtest.NewMemory()
+		m.Data["k"] = []byte("v1")
+		c := backing.Cache(m, backing.CacheArgs{TTL: 20 * time.Millisecond})
+
+		Expect(c.Get("k")).To(Equal([]byte("v1")))
+		m.Data["k"] = []byte("v2") // written behind the cache's back
+		Expect(c.Get("k")).To(Equal([]byte("v1")))
+		Eventually(func() []byte { v, _ := c.Get("k"); return v }).Should(Equal([]byte("v2")))
+	})
+
+	It("evicts the least recently used entries to stay under its byte limit", func() {
+		m := backingtest.NewMemory()
+		for _, k := range []string{"a", "b", "c"} {
+			m.Data[k] = []byte("123456789")
+		}
+		c := backing.Cache(m, backing.CacheArgs{MaxBytes: 20})
+
+		c.Get("a")
+		c.Get("b")
+		c.Get("a") // b is now least recently used
+		c.Get("c") // evicts b
+		m.Calls()
+
+		c.Get("a")
+		c.Get("c")
+		c.Get("b")
+		Expect(m.Calls()).To(Equal([]string{"get b"}))
+	})
+
+	It("revalidates stale entries by ETag", func() {
+		v := newVersioned()
+		Expect(v.Set("k", []byte("v1"))).To(Succeed())
+		c := backing.Cache(v, backing.CacheArgs{TTL: time.Nanosecond, Revalidate: true})
+		v.Calls()
+
+		Expect(c.Get("k")).To(Equal([]byte("v1")))
+		Expect(c.Get("k")).To(Equal([]byte("v1")))
+		Expect(v.Calls()).To(Equal([]string{"stat k", "get k", "stat k"}))
+
+		Expect(v.Set("k", []byte("v2"))).To(Succeed()) // behind the cache's back
+		v.Calls()
+		Expect(c.Get("k")).To(Equal([]byte("v2")))
+		Expect(v.Calls()).To(Equal([]string{"stat k", "get k"}))
+	})
+})
@@ -0,0 +1,156 @@
+package backing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrChecksumMismatch is returned when a value read from a backing does not match the checksum stored with it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// sumMagic marks a value written by a checksumming backing. It is followed by a ChecksumAlgorithm byte and the digest.
+var sumMagic = []byte("S3KC")
+
+// ChecksumAlgorithm is an algorithm used to detect corrupted values.
+type ChecksumAlgorithm byte
+
+const (
+	CRC32C ChecksumAlgorithm = iota + 1 // CRC-32 with the Castagnoli polynomial. Fast, and catches accidental corruption.
+	SHA256                              // SHA-256. Slower, but also resists deliberate tampering by anyone who can't rewrite the digest.
+)
+
+// String returns the name of the algorithm.
+func (a ChecksumAlgorithm) String() string {
+	switch a {
+	case CRC32C:
+		return "crc32c"
+	case SHA256:
+		return "sha256"
+	}
+	return fmt.Sprintf("checksum(%d)", byte(a))
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// sum returns the digest of a value, or nil if the algorithm is unknown.
+func (a ChecksumAlgorithm) sum(value []byte) []byte {
+	switch a {
+	case CRC32C:
+		d := crc32.Checksum(value, crc32c)
+		return []byte{byte(d >> 24), byte(d >> 16), byte(d >> 8), byte(d)}
+	case SHA256:
+		d := sha256.Sum256(value)
+		return d[:]
+	}
+	return nil
+}
+
+// ChecksumArgs are the arguments for a checksumming backing.
+type ChecksumArgs struct {
+	Algorithm ChecksumAlgorithm // Optional. The algorithm for new values. Defaults to CRC32C.
+	Require   bool              // Optional. Reject values without a checksum instead of returning them unverified.
+}
+
+// checksummed is a Backing which stores a checksum with each value and verifies it on read.
+type checksummed struct {
+	next Backing
+	args ChecksumArgs
+}
+
+// Checksum wraps a backing so that each value is stored with a checksum which is verified when it is read. A value
+// which fails verification returns an error wrapping ErrChecksumMismatch rather than corrupt bytes.
+//
+// Values written before checksums were enabled are returned unverified unless Require is set. Only a legacy value
+// starting with a well-formed checksum header, "S3KC", a known algorithm byte and a whole digest, is mistaken for a
+// checksummed one. The S3 backing also sends a Content-MD5 with each write, so S3 itself rejects values corrupted on
+// the way in.
+func Checksum(b Backing, args ChecksumArgs) Backing {
+	if args.Algorithm == 0 {
+		args.Algorithm = CRC32C
+	}
+	return &checksummed{next: b, args: args}
+}
+
+// ChecksumMiddleware returns middleware which wraps a backing with Checksum.
+func ChecksumMiddleware(args ChecksumArgs) Middleware {
+	return func(next Backing) Backing {
+		return Checksum(next, args)
+	}
+}
+
+// List lists all keys in the store with the given prefix.
+func (c *checksummed) List(prefix string) ([]Key, error) {
+	return c.next.List(prefix)
+}
+
+// Get returns the value for the given key after verifying its checksum.
+func (c *checksummed) Get(key Key) ([]byte, error) {
+	stored, err := c.next.Get(key)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	return c.verify(key, stored)
+}
+
+// verify checks a stored value against its checksum and returns the original value.
+func (c *checksummed) verify(key Key, stored []byte) ([]byte, error) {
+	a, want, value, ok := splitChecksum(stored)
+	if !ok {
+		if c.args.Require {
+			return nil, fmt.Errorf("%w: %s has no checksum", ErrChecksumMismatch, key)
+		}
+		return stored, nil
+	}
+	if got := a.sum(value); !bytes.Equal(got, want) {
+		return nil, fmt.Errorf("%w: %s has %s %x, expected %x", ErrChecksumMismatch, key, a, got, want)
+	}
+	return value, nil
+}
+
+// splitChecksum returns the algorithm, digest and value in a stored value. It returns false if the value has no
+// well-formed header, meaning the magic, a known algorithm and a whole digest, in which case it was written before
+// checksums were enabled, even if it happens to start with the magic.
+func splitChecksum(stored []byte) (ChecksumAlgorithm, []byte, []byte, bool) {
+	if len(stored) <= len(sumMagic) || !bytes.HasPrefix(stored, sumMagic) {
+		return 0, nil, nil, false
+	}
+	a := ChecksumAlgorithm(stored[len(sumMagic)])
+	n := len(a.sum(nil))
+	rest := stored[len(sumMagic)+1:]
+	if n == 0 || len(rest) < n {
+		return 0, nil, nil, false
+	}
+	return a, rest[:n], rest[n:], true
+}
+
+// Set stores the value for the given key along with its checksum.
+func (c *checksummed) Set(key Key, value []byte) error {
+	digest := c.args.Algorithm.sum(value)
+	if digest == nil {
+		return fmt.Errorf("unsupported checksum algorithm: %s", c.args.Algorithm)
+	}
+	stored := make([]byte, 0, len(sumMagic)+1+len(digest)+len(value))
+	stored = append(stored, sumMagic...)
+	stored = append(stored, byte(c.args.Algorithm))
+	stored = append(stored, digest...)
+	stored = append(stored, value...)
+	return c.next.Set(key, stored)
+}
+
+// Del deletes the key-value pair for the given key.
+func (c *checksummed) Del(key Key) error {
+	return c.next.Del(key)
+}
+
+// Stat returns information about the stored value for the given key. Size includes the checksum header.
+func (c *checksummed) Stat(key Key) (Info, error) {
+	return Stat(c.next, key)
+}
+
+// Supports returns true for Stats if the next backing supports it, which is the only optional operation forwarded.
+func (c *checksummed) Supports(op Capability) bool {
+	return op == Stats && Supports(c.next, op)
+}
@@ -0,0 +1,61 @@
+package backing_test
+
+import (
+	"errors"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Checksum", func() {
+	It("round-trips values with each algorithm", func() {
+		for _, a := range []backing.ChecksumAlgorithm{backing.CRC32C, backing.SHA256} {
+			m := backingtest.NewMemory()
+			b := backing.Checksum(m, backing.ChecksumArgs{Algorithm: a})
+
+			Expect(b.Set("k", []byte("value"))).To(Succeed())
+			Expect(m.Data["k"]).To(HavePrefix("S3KC" + string([]byte{byte(a)})))
+			Expect(b.Get("k")).To(Equal([]byte("value")), a.String())
+			Expect(b.Get("missing")).To(BeNil())
+			Expect(b.Set("empty", []byte{})).To(Succeed())
+			Expect(b.Get("empty")).To(BeEmpty())
+		}
+	})
+
+	It("detects corrupted values", func() {
+		m := backingtest.NewMemory()
+		b := backing.Checksum(m, backing.ChecksumArgs{})
+		Expect(b.Set("k", []byte("value"))).To(Succeed())
+
+		m.Data["k"][len(m.Data["k"])-1] ^= 1
+		_, err := b.Get("k")
+		Expect(errors.Is(err, backing.ErrChecksumMismatch)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("k has crc32c")))
+	})
+
+	It("returns legacy values unless checksums are required", func() {
+		m := backingtest.NewMemory()
+		m.Data["legacy"] = []byte("old value")
+
+		Expect(backing.Checksum(m, backing.ChecksumArgs{}).Get("legacy")).To(Equal([]byte("old value")))
+
+		_, err := backing.Checksum(m, backing.ChecksumArgs{Require: true}).Get("legacy")
+		Expect(errors.Is(err, backing.ErrChecksumMismatch)).To(BeTrue())
+	})
+
+	It("returns legacy values which look like they have a checksum unchanged", func() {
+		m := backingtest.NewMemory()
+		b := backing.Checksum(m, backing.ChecksumArgs{})
+		for _, v := range []string{"S3KC", "S3KC\x00abcdef", "S3KC\x09abcdef", "S3KC\x02abc"} {
+			m.Data["legacy"] = []byte(v)
+			Expect(b.Get("legacy")).To(Equal([]byte(v)))
+			_, err := backing.Checksum(m, backing.ChecksumArgs{Require: true}).Get("legacy")
+			Expect(errors.Is(err, backing.ErrChecksumMismatch)).To(BeTrue())
+		}
+
+		Expect(b.Set("k", []byte("S3KC\x01abcd"))).To(Succeed())
+		Expect(b.Get("k")).To(Equal([]byte("S3KC\x01abcd")))
+	})
+})
@@ -0,0 +1,261 @@
+package backing
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// compMagic marks a value written by a compressing backing. It is followed by an Algorithm byte.
+var compMagic = []byte("S3KZ")
+
+// Algorithm is a compression algorithm.
+type Algorithm byte
+
+const (
+	None   Algorithm = iota // Stored as-is. Used for small values which happen to start with the header.
+	Gzip                    // gzip, from the standard library. Widely compatible.
+	Zstd                    // Zstandard. Usually the best ratio for its speed.
+	Snappy                  // Snappy block format. Very fast, with a lower ratio.
+)
+
+// String returns the name of the algorithm.
+func (a Algorithm) String() string {
+	switch a {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zstd:
+		return "zstd"
+	case Snappy:
+		return "snappy"
+	}
+	return fmt.Sprintf("algorithm(%d)", byte(a))
+}
+
+// zstd encoders and decoders are safe for concurrent use with EncodeAll and DecodeAll, so one of each is shared.
+var (
+	zstdOnce sync.Once
+	zstdEnc  *zstd.Encoder
+	zstdDec  *zstd.Decoder
+)
+
+// zstdCodecs returns the shared zstd encoder and decoder.
+func zstdCodecs() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEnc, _ = zstd.NewWriter(nil)
+		zstdDec, _ = zstd.NewReader(nil)
+	})
+	return zstdEnc, zstdDec
+}
+
+// compress compresses a value with the given algorithm.
+func compress(a Algorithm, value []byte) ([]byte, error) {
+	switch a {
+	case Gzip:
+		buf := bytes.Buffer{}
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(value); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		enc, _ := zstdCodecs()
+		return enc.EncodeAll(value, nil), nil
+	case Snappy:
+		return s2.EncodeSnappy(nil, value), nil
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm: %s", a)
+}
+
+// decompress decompresses a value compressed with the given algorithm.
+func decompress(a Algorithm, data []byte) ([]byte, error) {
+	switch a {
+	case None:
+		return data, nil
+	case Gzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+	case Zstd:
+		_, dec := zstdCodecs()
+		return dec.DecodeAll(data, nil)
+	case Snappy:
+		return s2.Decode(nil, data)
+	}
+	return nil, fmt.Errorf("unsupported compression algorithm: %s", a)
+}
+
+// CompressArgs are the arguments for a compressing backing.
+type CompressArgs struct {
+	Algorithm Algorithm // Optional. The algorithm for new values. Defaults to Gzip.
+	MinSize   int       // Optional. Values smaller than this many bytes are stored uncompressed. Defaults to 256.
+}
+
+// Default values for CompressArgs, if unset.
+const defaultCompressMinSize = 256
+
+// CompressionStats describes the values written by a Compressor.
+type CompressionStats struct {
+	Values        int64 // Values written.
+	Compressed    int64 // Values written compressed. The rest were too small or didn't shrink.
+	BytesIn       int64 // Total size of the values written.
+	BytesOut      int64 // Total size of the values as stored.
+	BytesDecoded  int64 // Total size of the values read, after decompression.
+	RawValuesRead int64 // Values read without a header: written before compression was enabled, or too small or incompressible to compress.
+}
+
+// Ratio returns the overall compression ratio of the values written, such as 10 for values stored at a tenth of
+// their size. It is 1 if nothing has been written.
+func (s CompressionStats) Ratio() float64 {
+	if s.BytesOut == 0 {
+		return 1
+	}
+	return float64(s.BytesIn) / float64(s.BytesOut)
+}
+
+// Compressor is a Backing which compresses values before storing them in another Backing.
+//
+// Compressed values start with a short header naming the algorithm, so values written with any algorithm can be
+// read regardless of the current setting, and values written before compression was enabled are returned as-is.
+// Values below MinSize, or which don't shrink, are stored without a header unless they happen to begin with one.
+type Compressor struct {
+	next Backing
+	args CompressArgs
+
+	access sync.Mutex
+	stats  CompressionStats
+}
+
+// Compress wraps a backing so that values are compressed before they are stored.
+func Compress(b Backing, args CompressArgs) *Compressor {
+	if args.Algorithm == None {
+		args.Algorithm = Gzip
+	}
+	if args.MinSize == 0 {
+		args.MinSize = defaultCompressMinSize
+	}
+	return &Compressor{next: b, args: args}
+}
+
+// CompressMiddleware returns middleware which wraps a backing with Compress.
+func CompressMiddleware(args CompressArgs) Middleware {
+	return func(next Backing) Backing {
+		return Compress(next, args)
+	}
+}
+
+// Stats returns a snapshot of the compressor's statistics.
+func (c *Compressor) Stats() CompressionStats {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.stats
+}
+
+// encode returns the stored form of a value.
+func (c *Compressor) encode(value []byte) ([]byte, bool, error) {
+	headed := bytes.HasPrefix(value, compMagic)
+	if len(value) >= c.args.MinSize {
+		compressed, err := compress(c.args.Algorithm, value)
+		if err != nil {
+			return nil, false, err
+		}
+		if len(compressed)+len(compMagic)+1 < len(value) {
+			return c.header(c.args.Algorithm, compressed), true, nil
+		}
+	}
+	if headed {
+		return c.header(None, value), false, nil
+	}
+	return value, false, nil
+}
+
+// header prefixes data with the header for the given algorithm.
+func (c *Compressor) header(a Algorithm, data []byte) []byte {
+	out := make([]byte, 0, len(compMagic)+1+len(data))
+	out = append(out, compMagic...)
+	out = append(out, byte(a))
+	return append(out, data...)
+}
+
+// decode returns the original form of a stored value.
+func (c *Compressor) decode(key Key, stored []byte) ([]byte, error) {
+	if len(stored) <= len(compMagic) || !bytes.HasPrefix(stored, compMagic) {
+		c.access.Lock()
+		c.stats.RawValuesRead++
+		c.stats.BytesDecoded += int64(len(stored))
+		c.access.Unlock()
+		return stored, nil
+	}
+	a := Algorithm(stored[len(compMagic)])
+	value, err := decompress(a, stored[len(compMagic)+1:])
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s with %s: %w", key, a, err)
+	}
+	c.access.Lock()
+	c.stats.BytesDecoded += int64(len(value))
+	c.access.Unlock()
+	return value, nil
+}
+
+// List lists all keys in the store with the given prefix.
+func (c *Compressor) List(prefix string) ([]Key, error) {
+	return c.next.List(prefix)
+}
+
+// Get returns the decompressed value for the given key.
+func (c *Compressor) Get(key Key) ([]byte, error) {
+	stored, err := c.next.Get(key)
+	if err != nil || stored == nil {
+		return nil, err
+	}
+	return c.decode(key, stored)
+}
+
+// Set compresses and stores the value for the given key.
+func (c *Compressor) Set(key Key, value []byte) error {
+	stored, compressed, err := c.encode(value)
+	if err != nil {
+		return err
+	}
+	if err := c.next.Set(key, stored); err != nil {
+		return err
+	}
+
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.stats.Values++
+	if compressed {
+		c.stats.Compressed++
+	}
+	c.stats.BytesIn += int64(len(value))
+	c.stats.BytesOut += int64(len(stored))
+	return nil
+}
+
+// Del deletes the key-value pair for the given key.
+func (c *Compressor) Del(key Key) error {
+	return c.next.Del(key)
+}
+
+// Stat returns information about the stored value for the given key. Size is the compressed size.
+func (c *Compressor) Stat(key Key) (Info, error) {
+	return Stat(c.next, key)
+}
+
+// Supports returns true for Stats if the next backing supports it, which is the only optional operation forwarded.
+func (c *Compressor) Supports(op Capability) bool {
+	return op == Stats && Supports(c.next, op)
+}
@@ -0,0 +1,76 @@
+package backing_test
+
+import (
+	"strings"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compress", func() {
+	big := []byte(strings.Repeat("hello compression ", 100))
+
+	It("round-trips values with each algorithm", func() {
+		for _, a := range []backing.Algorithm{backing.Gzip, backing.Zstd, backing.Snappy} {
+			m := backingtest.NewMemory()
+			b := backing.Compress(m, backing.CompressArgs{Algorithm: a})
+
+			Expect(b.Set("k", big)).To(Succeed())
+			Expect(m.Data["k"]).To(HavePrefix("S3KZ" + string([]byte{byte(a)})))
+			Expect(len(m.Data["k"])).To(BeNumerically("<", len(big)/4), a.String())
+			Expect(b.Get("k")).To(Equal(big))
+			Expect(b.Get("missing")).To(BeNil())
+		}
+	})
+
+	It("reads values written with other algorithms or without compression", func() {
+		m := backingtest.NewMemory()
+		Expect(backing.Compress(m, backing.CompressArgs{Algorithm: backing.Zstd}).Set("z", big)).To(Succeed())
+		m.Data["legacy"] = []byte("written before compression")
+
+		b := backing.Compress(m, backing.CompressArgs{Algorithm: backing.Snappy})
+		Expect(b.Get("z")).To(Equal(big))
+		Expect(b.Get("legacy")).To(Equal([]byte("written before compression")))
+		Expect(b.Stats().RawValuesRead).To(BeEquivalentTo(1))
+
+		// small values are stored without a header too, so they are indistinguishable from legacy ones
+		Expect(b.Set("small", []byte("tiny"))).To(Succeed())
+		Expect(b.Get("small")).To(Equal([]byte("tiny")))
+		Expect(b.Stats().RawValuesRead).To(BeEquivalentTo(2))
+	})
+
+	It("stores small values as-is unless they look compressed", func() {
+		m := backingtest.NewMemory()
+		b := backing.Compress(m, backing.CompressArgs{MinSize: 64})
+
+		Expect(b.Set("small", []byte("tiny"))).To(Succeed())
+		Expect(m.Data["small"]).To(Equal([]byte("tiny")))
+
+		Expect(b.Set("tricky", []byte("S3KZ\x02not really"))).To(Succeed())
+		Expect(m.Data["tricky"]).To(HavePrefix("S3KZ\x00"))
+		Expect(b.Get("tricky")).To(Equal([]byte("S3KZ\x02not really")))
+	})
+
+	It("reports compression statistics", func() {
+		b := backing.Compress(backingtest.NewMemory(), backing.CompressArgs{})
+		Expect(b.Stats().Ratio()).To(Equal(1.0))
+
+		Expect(b.Set("big", big)).To(Succeed())
+		Expect(b.Set("small", []byte("tiny"))).To(Succeed())
+		stats := b.Stats()
+		Expect(stats.Values).To(BeEquivalentTo(2))
+		Expect(stats.Compressed).To(BeEquivalentTo(1))
+		Expect(stats.BytesIn).To(BeEquivalentTo(len(big) + 4))
+		Expect(stats.Ratio()).To(BeNumerically(">", 4))
+	})
+
+	It("reports corrupt values", func() {
+		m := backingtest.NewMemory()
+		b := backing.Compress(m, backing.CompressArgs{})
+		m.Data["bad"] = []byte("S3KZ\x01garbage")
+		_, err := b.Get("bad")
+		Expect(err).To(MatchError(ContainSubstring("decompressing bad with gzip")))
+	})
+})
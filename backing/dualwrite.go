@@ -0,0 +1,127 @@
+package backing
+
+import "errors"
+
+// DualWriteArgs are the arguments for a dual-writing backing.
+type DualWriteArgs struct {
+	OnSecondaryError func(op string, key Key, err error) // Optional. If set, failed writes to the secondary are reported here instead of failing the write.
+}
+
+// dualWrite is a Backing which writes to two backings and reads from the first.
+type dualWrite struct {
+	primary   Backing
+	secondary Backing
+	args      DualWriteArgs
+}
+
+// DualWrite wraps two backings so that reads come from the primary and writes go to both, primary first. Use it
+// during a cutover: dual-write while Migrate copies existing keys, then switch the store to the secondary.
+//
+// By default a write fails if either backing fails, although the primary may already have been written. Set
+// OnSecondaryError to keep serving from the primary when the secondary is unavailable, and catch up with Migrate.
+func DualWrite(primary, secondary Backing, args DualWriteArgs) Backing {
+	return &dualWrite{primary: primary, secondary: secondary, args: args}
+}
+
+// DualWriteMiddleware returns middleware which also writes everything to the given secondary backing.
+func DualWriteMiddleware(secondary Backing, args DualWriteArgs) Middleware {
+	return func(next Backing) Backing {
+		return DualWrite(next, secondary, args)
+	}
+}
+
+// mirror reports the outcome of a write to the secondary.
+func (d *dualWrite) mirror(op string, key Key, err error) error {
+	if err != nil && d.args.OnSecondaryError != nil {
+		d.args.OnSecondaryError(op, key, err)
+		return nil
+	}
+	return err
+}
+
+// List lists all keys in the primary with the given prefix.
+func (d *dualWrite) List(prefix string) ([]Key, error) {
+	return d.primary.List(prefix)
+}
+
+// Get returns the value for the given key from the primary.
+func (d *dualWrite) Get(key Key) ([]byte, error) {
+	return d.primary.Get(key)
+}
+
+// Set sets the value for the given key in both backings.
+func (d *dualWrite) Set(key Key, value []byte) error {
+	if err := d.primary.Set(key, value); err != nil {
+		return err
+	}
+	return d.mirror("Set", key, d.secondary.Set(key, value))
+}
+
+// Del deletes the key-value pair for the given key from both backings.
+func (d *dualWrite) Del(key Key) error {
+	if err := d.primary.Del(key); err != nil {
+		return err
+	}
+	return d.mirror("Del", key, d.secondary.Del(key))
+}
+
+// SetWithMeta sets the value and metadata for the given key in both backings. If the secondary can't store
+// metadata, it gets the value alone.
+func (d *dualWrite) SetWithMeta(key Key, value []byte, meta Meta) error {
+	if err := SetWithMeta(d.primary, key, value, meta); err != nil {
+		return err
+	}
+	err := SetWithMeta(d.secondary, key, value, meta)
+	if errors.Is(err, ErrUnsupported) {
+		err = d.secondary.Set(key, value)
+	}
+	return d.mirror("SetWithMeta", key, err)
+}
+
+// GetMany returns the values for the given keys from the primary.
+func (d *dualWrite) GetMany(keys []Key) (map[Key][]byte, error) {
+	return GetMany(d.primary, keys)
+}
+
+// DelMany deletes the given keys from both backings. A failure to delete from the secondary is reported for each key.
+func (d *dualWrite) DelMany(keys []Key) error {
+	if err := DelMany(d.primary, keys); err != nil {
+		return err
+	}
+	err := DelMany(d.secondary, keys)
+	if err != nil && d.args.OnSecondaryError != nil {
+		for _, key := range keys {
+			d.args.OnSecondaryError("DelMany", key, err)
+		}
+		return nil
+	}
+	return err
+}
+
+// Tag replaces the tags on the value for the given key in both backings. If the secondary can't store tags, it is
+// left untagged.
+func (d *dualWrite) Tag(key Key, tags map[string]string) error {
+	if err := Tag(d.primary, key, tags); err != nil {
+		return err
+	}
+	err := Tag(d.secondary, key, tags)
+	if errors.Is(err, ErrUnsupported) {
+		err = nil
+	}
+	return d.mirror("Tag", key, err)
+}
+
+// Stat returns information about the value for the given key from the primary.
+func (d *dualWrite) Stat(key Key) (Info, error) {
+	return Stat(d.primary, key)
+}
+
+// Supports returns true if the primary natively supports the given optional operation and the dual-writing backing
+// forwards it.
+func (d *dualWrite) Supports(c Capability) bool {
+	switch c {
+	case Batching, Stats, Tagging, Metadata:
+		return Supports(d.primary, c)
+	}
+	return false
+}
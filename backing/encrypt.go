@@ -0,0 +1,303 @@
+package backing
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// encMagic marks a value sealed by an encrypting backing. It is followed by a format version byte.
+var encMagic = []byte("S3KE")
+
+// encVersion is the current sealed value format.
+const encVersion = 1
+
+// dataKeySize is the size of the AES-256 key generated for each value.
+const dataKeySize = 32
+
+// ErrNotEncrypted is returned when reading a value which was not sealed by an encrypting backing.
+var ErrNotEncrypted = errors.New("value is not encrypted")
+
+// ErrKeysHidden is returned by List on an encrypting backing with KeyMAC set, since HMACed names can't be mapped
+// back to keys.
+var ErrKeysHidden = errors.New("key names are hidden by KeyMAC and can't be listed")
+
+// KeyProvider supplies the key-encryption keys used to wrap each value's data key. Implement it to keep master keys
+// in a KMS or HSM.
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key used to wrap new data keys.
+	CurrentKeyID() string
+	// WrapKey encrypts a data key with the key-encryption key with the given ID.
+	WrapKey(keyID string, dataKey []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped with the key-encryption key with the given ID.
+	UnwrapKey(keyID string, wrapped []byte) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider backed by AES key-encryption keys held in memory, keyed by ID. Current names the key
+// used for new values; the others are kept so older values can still be read.
+type StaticKeys struct {
+	Current string
+	Keys    map[string][]byte // 16, 24 or 32 bytes each
+}
+
+// CurrentKeyID returns the ID of the key used to wrap new data keys.
+func (k StaticKeys) CurrentKeyID() string {
+	return k.Current
+}
+
+// aead returns an AES-GCM cipher for the key with the given ID.
+func (k StaticKeys) aead(keyID string) (cipher.AEAD, error) {
+	kek, ok := k.Keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID: %s", keyID)
+	}
+	return newGCM(kek)
+}
+
+// WrapKey encrypts a data key with AES-GCM under the key with the given ID.
+func (k StaticKeys) WrapKey(keyID string, dataKey []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, dataKey, []byte(keyID)), nil
+}
+
+// UnwrapKey decrypts a data key wrapped by WrapKey.
+func (k StaticKeys) UnwrapKey(keyID string, wrapped []byte) ([]byte, error) {
+	aead, err := k.aead(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("wrapped key is truncated")
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, wrapped[:n], wrapped[n:], []byte(keyID))
+}
+
+// newGCM returns an AES-GCM cipher for the given key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptArgs are the arguments for an encrypting backing.
+type EncryptArgs struct {
+	Keys         KeyProvider // Required. Supplies the keys which wrap each value's data key.
+	RotateOnRead bool        // Optional. Re-seal values under the current key when they are read with an older one.
+	KeyMAC       []byte      // Optional. If set, the last path segment of each key is replaced by its HMAC-SHA256 under this secret.
+}
+
+// encrypting is a Backing which seals values with envelope encryption before storing them in another Backing.
+type encrypting struct {
+	next Backing
+	args EncryptArgs
+}
+
+// Encrypt wraps a backing so that values are sealed with AES-256-GCM under a fresh data key, which is itself wrapped
+// by the key provider. The key ID and wrapped data key are stored in a header with each value, so keys can be
+// rotated without rewriting existing values, and each value is bound to its key so values can't be swapped.
+//
+// With RotateOnRead, values sealed under an older key are re-sealed and written back when read. The write-back
+// happens outside of any Store session, so it is skipped if the stored value changed since it was read, but a
+// concurrent writer could still race it; only enable it where reads and writes of a key are already serialized.
+//
+// With KeyMAC, object names are HMACed so they don't reveal identifiers. Only the last "/"-separated segment is
+// replaced. The HMAC can't be reversed, so List fails with ErrKeysHidden, as do Store operations which list keys,
+// such as Keys, Sweep, Snapshot and exports; only use KeyMAC where every key is known to the caller.
+func Encrypt(b Backing, args EncryptArgs) Backing {
+	return &encrypting{next: b, args: args}
+}
+
+// EncryptMiddleware returns middleware which wraps a backing with Encrypt.
+func EncryptMiddleware(args EncryptArgs) Middleware {
+	return func(next Backing) Backing {
+		return Encrypt(next, args)
+	}
+}
+
+// name returns the object name a key is stored under.
+func (e *encrypting) name(key Key) Key {
+	if e.args.KeyMAC == nil {
+		return key
+	}
+	dir, base := "", key
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		dir, base = key[:i+1], key[i+1:]
+	}
+	mac := hmac.New(sha256.New, e.args.KeyMAC)
+	mac.Write([]byte(base))
+	return dir + hex.EncodeToString(mac.Sum(nil))
+}
+
+// seal encrypts a value for the given key under the provider's current key.
+func (e *encrypting) seal(key Key, value []byte) ([]byte, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	keyID := e.args.Keys.CurrentKeyID()
+	if len(keyID) > 255 {
+		return nil, fmt.Errorf("key ID is too long: %s", keyID)
+	}
+	wrapped, err := e.args.Keys.WrapKey(keyID, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) > 65535 {
+		return nil, errors.New("wrapped key is too long")
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	buf.Write(encMagic)
+	buf.WriteByte(encVersion)
+	buf.WriteByte(byte(len(keyID)))
+	buf.WriteString(keyID)
+	binary.Write(&buf, binary.BigEndian, uint16(len(wrapped)))
+	buf.Write(wrapped)
+	buf.Write(nonce)
+	return aead.Seal(buf.Bytes(), nonce, value, []byte(key)), nil
+}
+
+// open decrypts a sealed value for the given key, returning the ID of the key it was sealed with.
+func (e *encrypting) open(key Key, sealed []byte) (value []byte, keyID string, err error) {
+	r := bytes.NewReader(sealed)
+	magic := make([]byte, len(encMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || !bytes.Equal(magic, encMagic) {
+		return nil, "", fmt.Errorf("%w: %s", ErrNotEncrypted, key)
+	}
+	version, err := r.ReadByte()
+	if err != nil {
+		return nil, "", err
+	}
+	if version != encVersion {
+		return nil, "", fmt.Errorf("unsupported encryption format version %d for key %s", version, key)
+	}
+	idLen, err := r.ReadByte()
+	if err != nil {
+		return nil, "", err
+	}
+	id := make([]byte, idLen)
+	if _, err := io.ReadFull(r, id); err != nil {
+		return nil, "", err
+	}
+	var wrappedLen uint16
+	if err := binary.Read(r, binary.BigEndian, &wrappedLen); err != nil {
+		return nil, "", err
+	}
+	wrapped := make([]byte, wrappedLen)
+	if _, err := io.ReadFull(r, wrapped); err != nil {
+		return nil, "", err
+	}
+
+	dataKey, err := e.args.Keys.UnwrapKey(string(id), wrapped)
+	if err != nil {
+		return nil, "", fmt.Errorf("unwrapping data key for %s: %w", key, err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return nil, "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, "", err
+	}
+	ciphertext := sealed[len(sealed)-r.Len():]
+	value, err = aead.Open(nil, nonce, ciphertext, []byte(key))
+	if err != nil {
+		return nil, "", fmt.Errorf("decrypting %s: %w", key, err)
+	}
+	return value, string(id), nil
+}
+
+// List lists all keys with the given prefix, or returns ErrKeysHidden if KeyMAC is set.
+func (e *encrypting) List(prefix string) ([]Key, error) {
+	if e.args.KeyMAC != nil {
+		return nil, ErrKeysHidden
+	}
+	return e.next.List(prefix)
+}
+
+// Get returns the decrypted value for the given key.
+func (e *encrypting) Get(key Key) ([]byte, error) {
+	name := e.name(key)
+	sealed, err := e.next.Get(name)
+	if err != nil || sealed == nil {
+		return nil, err
+	}
+	value, keyID, err := e.open(key, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if e.args.RotateOnRead && keyID != e.args.Keys.CurrentKeyID() {
+		if err := e.rotate(key, name, sealed, value); err != nil {
+			return nil, err
+		}
+	}
+	return value, nil
+}
+
+// rotate re-seals a value under the current key and writes it back, unless it has changed since it was read.
+func (e *encrypting) rotate(key Key, name Key, sealed []byte, value []byte) error {
+	resealed, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+	current, err := e.next.Get(name)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(current, sealed) {
+		return nil // someone else wrote it; their value is already sealed under a current key
+	}
+	return e.next.Set(name, resealed)
+}
+
+// Set encrypts and stores the value for the given key.
+func (e *encrypting) Set(key Key, value []byte) error {
+	sealed, err := e.seal(key, value)
+	if err != nil {
+		return err
+	}
+	return e.next.Set(e.name(key), sealed)
+}
+
+// Del deletes the key-value pair for the given key.
+func (e *encrypting) Del(key Key) error {
+	return e.next.Del(e.name(key))
+}
+
+// Stat returns information about the sealed value for the given key. Size is the size of the sealed value.
+func (e *encrypting) Stat(key Key) (Info, error) {
+	return Stat(e.next, e.name(key))
+}
+
+// Supports returns true for Stats if the next backing supports it, which is the only optional operation forwarded.
+func (e *encrypting) Supports(op Capability) bool {
+	return op == Stats && Supports(e.next, op)
+}
@@ -0,0 +1,91 @@
+package backing_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encrypt", func() {
+	keys := func(current string) backing.StaticKeys {
+		return backing.StaticKeys{
+			Current: current,
+			Keys: map[string][]byte{
+				"old": bytes.Repeat([]byte{1}, 32),
+				"new": bytes.Repeat([]byte{2}, 32),
+			},
+		}
+	}
+
+	It("round-trips values without storing plaintext", func() {
+		m := backingtest.NewMemory()
+		b := backing.Encrypt(m, backing.EncryptArgs{Keys: keys("old")})
+
+		Expect(b.Set("ns/k", []byte("secret value"))).To(Succeed())
+		Expect(m.Data["ns/k"]).ToNot(ContainSubstring("secret"))
+		Expect(string(m.Data["ns/k"])).To(HavePrefix("S3KE\x01\x03old"))
+		Expect(b.Get("ns/k")).To(Equal([]byte("secret value")))
+		Expect(b.Get("ns/missing")).To(BeNil())
+
+		Expect(b.Del("ns/k")).To(Succeed())
+		Expect(m.Data).To(BeEmpty())
+	})
+
+	It("rejects values that were tampered with, moved or never encrypted", func() {
+		m := backingtest.NewMemory()
+		b := backing.Encrypt(m, backing.EncryptArgs{Keys: keys("old")})
+		Expect(b.Set("a", []byte("value"))).To(Succeed())
+
+		m.Data["b"] = m.Data["a"]
+		_, err := b.Get("b")
+		Expect(err).To(MatchError(ContainSubstring("decrypting b")))
+
+		m.Data["a"][len(m.Data["a"])-1] ^= 1
+		_, err = b.Get("a")
+		Expect(err).To(HaveOccurred())
+
+		m.Data["plain"] = []byte("hello")
+		_, err = b.Get("plain")
+		Expect(errors.Is(err, backing.ErrNotEncrypted)).To(BeTrue())
+	})
+
+	It("reads values sealed with older keys and rotates them lazily", func() {
+		m := backingtest.NewMemory()
+		Expect(backing.Encrypt(m, backing.EncryptArgs{Keys: keys("old")}).Set("k", []byte("v"))).To(Succeed())
+
+		b := backing.Encrypt(m, backing.EncryptArgs{Keys: keys("new")})
+		Expect(b.Get("k")).To(Equal([]byte("v")))
+		Expect(string(m.Data["k"])).To(HavePrefix("S3KE\x01\x03old"))
+
+		b = backing.Encrypt(m, backing.EncryptArgs{Keys: keys("new"), RotateOnRead: true})
+		Expect(b.Get("k")).To(Equal([]byte("v")))
+		Expect(string(m.Data["k"])).To(HavePrefix("S3KE\x01\x03new"))
+
+		// once rotated, the old key is no longer needed
+		onlyNew := backing.StaticKeys{Current: "new", Keys: map[string][]byte{"new": keys("new").Keys["new"]}}
+		Expect(backing.Encrypt(m, backing.EncryptArgs{Keys: onlyNew}).Get("k")).To(Equal([]byte("v")))
+	})
+
+	It("hides key names with an HMAC", func() {
+		m := backingtest.NewMemory()
+		b := backing.Encrypt(m, backing.EncryptArgs{Keys: keys("old"), KeyMAC: []byte("mac secret")})
+
+		Expect(b.Set("users/alice@example.com", []byte("v"))).To(Succeed())
+		Expect(b.Get("users/alice@example.com")).To(Equal([]byte("v")))
+
+		stored, err := m.List("users/")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(stored).To(HaveLen(1))
+		Expect(stored[0]).ToNot(ContainSubstring("alice"))
+		Expect(strings.TrimPrefix(stored[0], "users/")).To(HaveLen(64))
+
+		// the stored names can't be turned back into keys, so listing through the backing is refused
+		_, err = b.List("users/")
+		Expect(err).To(MatchError(backing.ErrKeysHidden))
+	})
+})
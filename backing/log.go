@@ -0,0 +1,176 @@
+package backing
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/mplewis/s3kv/logging"
+)
+
+// logged is a Backing which logs every operation on another Backing.
+type logged struct {
+	next      Backing
+	logger    logging.Logger
+	namespace string
+}
+
+// Log wraps a backing so that every operation is logged with its key, duration and error. Successful operations are
+// logged at Debug level and failures at Error level. The namespace is attached to every entry. Optional operations
+// are passed through to the backing.
+func Log(b Backing, l logging.Logger, namespace string) Backing {
+	return &logged{next: b, logger: l, namespace: namespace}
+}
+
+// LogMiddleware returns middleware which wraps a backing with Log.
+func LogMiddleware(l logging.Logger, namespace string) Middleware {
+	return func(next Backing) Backing {
+		return Log(next, l, namespace)
+	}
+}
+
+// log emits the entry for a single operation.
+func (b *logged) log(op string, key string, start time.Time, err error) {
+	b.logMany(op, key, nil, start, err)
+}
+
+// logMany emits the entry for a single operation on any number of keys. Optional operations which the next backing
+// doesn't support are not logged.
+func (b *logged) logMany(op string, key string, keys []Key, start time.Time, err error) {
+	if errors.Is(err, ErrUnsupported) {
+		return
+	}
+	e := logging.Entry{
+		Time:      time.Now(),
+		Level:     logging.Debug,
+		Message:   "backing operation",
+		Op:        op,
+		Namespace: b.namespace,
+		Key:       key,
+		Keys:      keys,
+		Duration:  time.Since(start),
+		Err:       err,
+	}
+	if err != nil {
+		e.Level = logging.Error
+		e.Message = "backing operation failed"
+	}
+	b.logger.Log(e)
+}
+
+// Supports returns true if the next backing natively supports the given optional operation.
+func (b *logged) Supports(c Capability) bool {
+	return Supports(b.next, c)
+}
+
+// List lists all keys in the store with the given prefix.
+func (b *logged) List(prefix string) ([]Key, error) {
+	start := time.Now()
+	keys, err := b.next.List(prefix)
+	b.log("List", prefix, start, err)
+	return keys, err
+}
+
+// Get returns the value for the given key.
+func (b *logged) Get(key Key) ([]byte, error) {
+	start := time.Now()
+	value, err := b.next.Get(key)
+	b.log("Get", key, start, err)
+	return value, err
+}
+
+// Set sets the value for the given key.
+func (b *logged) Set(key Key, value []byte) error {
+	start := time.Now()
+	err := b.next.Set(key, value)
+	b.log("Set", key, start, err)
+	return err
+}
+
+// Del deletes the key-value pair for the given key.
+func (b *logged) Del(key Key) error {
+	start := time.Now()
+	err := b.next.Del(key)
+	b.log("Del", key, start, err)
+	return err
+}
+
+// GetStream returns a reader for the value of the given key. The duration covers opening the stream.
+func (b *logged) GetStream(key Key) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := GetStream(b.next, key)
+	b.log("GetStream", key, start, err)
+	return r, err
+}
+
+// SetStream sets the value for the given key from a reader.
+func (b *logged) SetStream(key Key, r io.Reader) error {
+	start := time.Now()
+	err := SetStream(b.next, key, r)
+	b.log("SetStream", key, start, err)
+	return err
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist.
+func (b *logged) SetIfAbsent(key Key, value []byte) (bool, error) {
+	start := time.Now()
+	ok, err := SetIfAbsent(b.next, key, value)
+	b.log("SetIfAbsent", key, start, err)
+	return ok, err
+}
+
+// GetMany returns the values for the given keys.
+func (b *logged) GetMany(keys []Key) (map[Key][]byte, error) {
+	start := time.Now()
+	values, err := GetMany(b.next, keys)
+	b.logMany("GetMany", "", keys, start, err)
+	return values, err
+}
+
+// DelMany deletes the given keys.
+func (b *logged) DelMany(keys []Key) error {
+	start := time.Now()
+	err := DelMany(b.next, keys)
+	b.logMany("DelMany", "", keys, start, err)
+	return err
+}
+
+// Stat returns information about the value for the given key.
+func (b *logged) Stat(key Key) (Info, error) {
+	start := time.Now()
+	info, err := Stat(b.next, key)
+	b.log("Stat", key, start, err)
+	return info, err
+}
+
+// Tag replaces the tags on the value for the given key.
+func (b *logged) Tag(key Key, tags map[string]string) error {
+	start := time.Now()
+	err := Tag(b.next, key, tags)
+	b.log("Tag", key, start, err)
+	return err
+}
+
+// SetWithMeta sets the value and metadata for the given key.
+func (b *logged) SetWithMeta(key Key, value []byte, meta Meta) error {
+	start := time.Now()
+	err := SetWithMeta(b.next, key, value, meta)
+	b.log("SetWithMeta", key, start, err)
+	return err
+}
+
+// Versions lists the versions of the given key.
+func (b *logged) Versions(key Key) ([]Version, error) {
+	start := time.Now()
+	versions, err := Versions(b.next, key)
+	b.log("Versions", key, start, err)
+	return versions, err
+}
+
+// GetVersion returns the value of the given version of a key.
+func (b *logged) GetVersion(key Key, id string) ([]byte, error) {
+	start := time.Now()
+	value, err := GetVersion(b.next, key, id)
+	b.log("GetVersion", key, start, err)
+	return value, err
+}
@@ -0,0 +1,27 @@
+package backing_test
+
+import (
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	"github.com/mplewis/s3kv/logging"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Log", func() {
+	It("logs each operation with its outcome", func() {
+		var got []logging.Entry
+		l := logging.LoggerFunc(func(e logging.Entry) { got = append(got, e) })
+
+		Expect(backing.Log(backingtest.NewMemory(), l, "ns").Set("k", []byte("v"))).To(Succeed())
+		Expect(backing.Log(failing{}, l, "ns").Del("k")).To(MatchError(errBoom))
+
+		Expect(got).To(HaveLen(2))
+		Expect(got[0].Level).To(Equal(logging.Debug))
+		Expect(got[0].Op).To(Equal("Set"))
+		Expect(got[0].Key).To(Equal("k"))
+		Expect(got[0].Namespace).To(Equal("ns"))
+		Expect(got[1].Level).To(Equal(logging.Error))
+		Expect(got[1].Err).To(MatchError(errBoom))
+	})
+})
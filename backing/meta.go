@@ -0,0 +1,29 @@
+package backing
+
+// Meta is metadata stored alongside a value.
+type Meta struct {
+	ContentType  string            `json:"content_type,omitempty"`  // The MIME type of the value, such as "application/json".
+	CacheControl string            `json:"cache_control,omitempty"` // Caching directives for clients which fetch the value over HTTP.
+	User         map[string]string `json:"user,omitempty"`          // Custom name-value pairs. S3 lowercases the names.
+}
+
+// IsZero returns true if no metadata is set.
+func (m Meta) IsZero() bool {
+	return m.ContentType == "" && m.CacheControl == "" && len(m.User) == 0
+}
+
+// MetaWriter is implemented by backings which can store metadata with a value, such as S3 object headers. Stored
+// metadata is returned in Info.Meta by Stat.
+type MetaWriter interface {
+	// SetWithMeta sets the value and metadata for the given key.
+	SetWithMeta(key Key, value []byte, meta Meta) error
+}
+
+// SetWithMeta sets the value and metadata for the given key, or returns ErrUnsupported if the backing is not a
+// MetaWriter.
+func SetWithMeta(b Backing, key Key, value []byte, meta Meta) error {
+	if w, ok := b.(MetaWriter); ok {
+		return w.SetWithMeta(key, value, meta)
+	}
+	return ErrUnsupported
+}
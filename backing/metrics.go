@@ -0,0 +1,186 @@
+package backing
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"github.com/mplewis/s3kv/metrics"
+)
+
+// Metric names recorded by an instrumented backing.
+const (
+	MetricOperations = "s3kv_backing_operations_total"           // Operations, labelled by backing, op and result.
+	MetricDuration   = "s3kv_backing_operation_duration_seconds" // Operation latency, labelled by backing and op.
+	MetricBytes      = "s3kv_backing_bytes_total"                // Value bytes moved, labelled by backing and direction.
+)
+
+// instrumented is a Backing which records metrics about every operation on another Backing.
+type instrumented struct {
+	next    Backing
+	metrics metrics.Metrics
+	name    string
+}
+
+// Instrument wraps a backing so that every operation is counted and timed, and the bytes read and written are
+// totalled. Streamed values are counted as operations, but their bytes are not totalled. The name is attached to
+// every series as the "backing" label. Optional operations are passed through to the backing.
+func Instrument(b Backing, m metrics.Metrics, name string) Backing {
+	return &instrumented{next: b, metrics: m, name: name}
+}
+
+// InstrumentMiddleware returns middleware which wraps a backing with Instrument.
+func InstrumentMiddleware(m metrics.Metrics, name string) Middleware {
+	return func(next Backing) Backing {
+		return Instrument(next, m, name)
+	}
+}
+
+// Supports returns true if the next backing natively supports the given optional operation.
+func (b *instrumented) Supports(c Capability) bool {
+	return Supports(b.next, c)
+}
+
+// record records the outcome of a single operation. Optional operations which the next backing doesn't support
+// are not recorded.
+func (b *instrumented) record(op string, start time.Time, err error) {
+	if errors.Is(err, ErrUnsupported) {
+		return
+	}
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	b.metrics.Count(MetricOperations, metrics.Labels{"backing": b.name, "op": op, "result": result}, 1)
+	b.metrics.Observe(MetricDuration, metrics.Labels{"backing": b.name, "op": op}, time.Since(start).Seconds())
+}
+
+// List lists all keys in the store with the given prefix.
+func (b *instrumented) List(prefix string) ([]Key, error) {
+	start := time.Now()
+	keys, err := b.next.List(prefix)
+	b.record("list", start, err)
+	return keys, err
+}
+
+// Get returns the value for the given key.
+func (b *instrumented) Get(key Key) ([]byte, error) {
+	start := time.Now()
+	value, err := b.next.Get(key)
+	b.record("get", start, err)
+	b.metrics.Count(MetricBytes, metrics.Labels{"backing": b.name, "direction": "read"}, float64(len(value)))
+	return value, err
+}
+
+// Set sets the value for the given key.
+func (b *instrumented) Set(key Key, value []byte) error {
+	start := time.Now()
+	err := b.next.Set(key, value)
+	b.record("set", start, err)
+	if err == nil {
+		b.metrics.Count(MetricBytes, metrics.Labels{"backing": b.name, "direction": "write"}, float64(len(value)))
+	}
+	return err
+}
+
+// Del deletes the key-value pair for the given key.
+func (b *instrumented) Del(key Key) error {
+	start := time.Now()
+	err := b.next.Del(key)
+	b.record("del", start, err)
+	return err
+}
+
+// GetStream returns a reader for the value of the given key.
+func (b *instrumented) GetStream(key Key) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := GetStream(b.next, key)
+	b.record("get_stream", start, err)
+	return r, err
+}
+
+// SetStream sets the value for the given key from a reader.
+func (b *instrumented) SetStream(key Key, r io.Reader) error {
+	start := time.Now()
+	err := SetStream(b.next, key, r)
+	b.record("set_stream", start, err)
+	return err
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist.
+func (b *instrumented) SetIfAbsent(key Key, value []byte) (bool, error) {
+	start := time.Now()
+	ok, err := SetIfAbsent(b.next, key, value)
+	b.record("set_if_absent", start, err)
+	if ok {
+		b.metrics.Count(MetricBytes, metrics.Labels{"backing": b.name, "direction": "write"}, float64(len(value)))
+	}
+	return ok, err
+}
+
+// GetMany returns the values for the given keys.
+func (b *instrumented) GetMany(keys []Key) (map[Key][]byte, error) {
+	start := time.Now()
+	values, err := GetMany(b.next, keys)
+	b.record("get_many", start, err)
+	n := 0
+	for _, value := range values {
+		n += len(value)
+	}
+	b.metrics.Count(MetricBytes, metrics.Labels{"backing": b.name, "direction": "read"}, float64(n))
+	return values, err
+}
+
+// DelMany deletes the given keys.
+func (b *instrumented) DelMany(keys []Key) error {
+	start := time.Now()
+	err := DelMany(b.next, keys)
+	b.record("del_many", start, err)
+	return err
+}
+
+// Tag replaces the tags on the value for the given key.
+func (b *instrumented) Tag(key Key, tags map[string]string) error {
+	start := time.Now()
+	err := Tag(b.next, key, tags)
+	b.record("tag", start, err)
+	return err
+}
+
+// SetWithMeta sets the value and metadata for the given key.
+func (b *instrumented) SetWithMeta(key Key, value []byte, meta Meta) error {
+	start := time.Now()
+	err := SetWithMeta(b.next, key, value, meta)
+	b.record("set", start, err)
+	if err == nil {
+		b.metrics.Count(MetricBytes, metrics.Labels{"backing": b.name, "direction": "write"}, float64(len(value)))
+	}
+	return err
+}
+
+// Stat returns information about the value for the given key.
+func (b *instrumented) Stat(key Key) (Info, error) {
+	start := time.Now()
+	info, err := Stat(b.next, key)
+	b.record("stat", start, err)
+	return info, err
+}
+
+// Versions lists the versions of the given key.
+func (b *instrumented) Versions(key Key) ([]Version, error) {
+	start := time.Now()
+	versions, err := Versions(b.next, key)
+	b.record("versions", start, err)
+	return versions, err
+}
+
+// GetVersion returns the value of the given version of a key.
+func (b *instrumented) GetVersion(key Key, id string) ([]byte, error) {
+	start := time.Now()
+	value, err := GetVersion(b.next, key, id)
+	b.record("get_version", start, err)
+	if err == nil {
+		b.metrics.Count(MetricBytes, metrics.Labels{"backing": b.name, "direction": "read"}, float64(len(value)))
+	}
+	return value, err
+}
@@ -0,0 +1,209 @@
+package backing
+
+import "io"
+
+// Middleware wraps a backing to add behavior, such as metrics, retries, caching or encryption.
+type Middleware func(next Backing) Backing
+
+// Chain wraps base in the given middleware. The first middleware is the outermost, so it sees each call first.
+//
+// Every layer of the result implements Streamer, ConditionalWriter, Batcher, Statter, Tagger and MetaWriter. A
+// layer uses its middleware's own implementation of an optional operation if it has one, and otherwise emulates it
+// through the middleware's core methods, so wrappers which transform values still see every value. Conditional
+// writes and writes with metadata can't be emulated, so they return ErrUnsupported unless the middleware supports
+// them. Middleware built with Intercept passes optional operations straight through to the next backing when it
+// doesn't hook the corresponding core operation. Since every layer claims these interfaces, check for an operation
+// with Supports rather than a type assertion. Versioner is not exposed, since versions are read without the
+// middleware's transforms.
+func Chain(base Backing, mws ...Middleware) Backing {
+	b := base
+	for i := len(mws) - 1; i >= 0; i-- {
+		b = &layer{Backing: mws[i](b)}
+	}
+	return b
+}
+
+// layer is a single middleware in a chain. It exposes every optional interface.
+type layer struct {
+	Backing
+}
+
+// Supports returns true if the layer's middleware supports the given optional operation natively.
+func (l *layer) Supports(c Capability) bool {
+	return c != Versioning && Supports(l.Backing, c)
+}
+
+// GetStream returns a reader for the value of the given key.
+func (l *layer) GetStream(key Key) (io.ReadCloser, error) {
+	return GetStream(l.Backing, key)
+}
+
+// SetStream sets the value for the given key from a reader.
+func (l *layer) SetStream(key Key, r io.Reader) error {
+	return SetStream(l.Backing, key, r)
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist.
+func (l *layer) SetIfAbsent(key Key, value []byte) (bool, error) {
+	return SetIfAbsent(l.Backing, key, value)
+}
+
+// GetMany returns the values for the given keys.
+func (l *layer) GetMany(keys []Key) (map[Key][]byte, error) {
+	return GetMany(l.Backing, keys)
+}
+
+// DelMany deletes the given keys.
+func (l *layer) DelMany(keys []Key) error {
+	return DelMany(l.Backing, keys)
+}
+
+// Stat returns information about the value for the given key.
+func (l *layer) Stat(key Key) (Info, error) {
+	return Stat(l.Backing, key)
+}
+
+// Tag replaces the tags on the value for the given key.
+func (l *layer) Tag(key Key, tags map[string]string) error {
+	return Tag(l.Backing, key, tags)
+}
+
+// SetWithMeta sets the value and metadata for the given key.
+func (l *layer) SetWithMeta(key Key, value []byte, meta Meta) error {
+	return SetWithMeta(l.Backing, key, value, meta)
+}
+
+// Interceptor hooks individual backing operations. Each hook receives the arguments and a next function which
+// performs the operation on the wrapped backing. Hooks left nil pass straight through.
+type Interceptor struct {
+	List func(prefix string, next func(prefix string) ([]Key, error)) ([]Key, error)
+	Get  func(key Key, next func(key Key) ([]byte, error)) ([]byte, error)
+	Set  func(key Key, value []byte, next func(key Key, value []byte) error) error
+	Del  func(key Key, next func(key Key) error) error
+}
+
+// Intercept builds middleware from per-operation hooks, so a wrapper only has to implement the operations it
+// cares about. Optional operations pass through to the next backing unless the matching core operation is hooked,
+// in which case they are emulated through the hook.
+func Intercept(i Interceptor) Middleware {
+	return func(next Backing) Backing {
+		return &intercepted{next: next, hooks: i}
+	}
+}
+
+// intercepted is a Backing built by Intercept.
+type intercepted struct {
+	next  Backing
+	hooks Interceptor
+}
+
+// List lists all keys in the store with the given prefix.
+func (b *intercepted) List(prefix string) ([]Key, error) {
+	if b.hooks.List == nil {
+		return b.next.List(prefix)
+	}
+	return b.hooks.List(prefix, b.next.List)
+}
+
+// Get returns the value for the given key.
+func (b *intercepted) Get(key Key) ([]byte, error) {
+	if b.hooks.Get == nil {
+		return b.next.Get(key)
+	}
+	return b.hooks.Get(key, b.next.Get)
+}
+
+// Set sets the value for the given key.
+func (b *intercepted) Set(key Key, value []byte) error {
+	if b.hooks.Set == nil {
+		return b.next.Set(key, value)
+	}
+	return b.hooks.Set(key, value, b.next.Set)
+}
+
+// Del deletes the key-value pair for the given key.
+func (b *intercepted) Del(key Key) error {
+	if b.hooks.Del == nil {
+		return b.next.Del(key)
+	}
+	return b.hooks.Del(key, b.next.Del)
+}
+
+// core hides the optional interfaces of an intercepted backing, so that helpers emulate them through its hooks.
+type core struct {
+	Backing
+}
+
+// Supports returns true if the given optional operation passes straight through to a next backing which supports
+// it natively.
+func (b *intercepted) Supports(c Capability) bool {
+	switch c {
+	case Streaming:
+		return b.hooks.Get == nil && b.hooks.Set == nil && Supports(b.next, c)
+	case ConditionalWrites, Metadata:
+		return b.hooks.Set == nil && Supports(b.next, c)
+	case Batching:
+		return b.hooks.Get == nil && b.hooks.Del == nil && Supports(b.next, c)
+	case Stats, Tagging:
+		return Supports(b.next, c)
+	}
+	return false
+}
+
+// GetStream returns a reader for the value of the given key.
+func (b *intercepted) GetStream(key Key) (io.ReadCloser, error) {
+	if b.hooks.Get == nil {
+		return GetStream(b.next, key)
+	}
+	return GetStream(core{b}, key)
+}
+
+// SetStream sets the value for the given key from a reader.
+func (b *intercepted) SetStream(key Key, r io.Reader) error {
+	if b.hooks.Set == nil {
+		return SetStream(b.next, key, r)
+	}
+	return SetStream(core{b}, key, r)
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist.
+func (b *intercepted) SetIfAbsent(key Key, value []byte) (bool, error) {
+	if b.hooks.Set == nil {
+		return SetIfAbsent(b.next, key, value)
+	}
+	return false, ErrUnsupported
+}
+
+// SetWithMeta sets the value and metadata for the given key.
+func (b *intercepted) SetWithMeta(key Key, value []byte, meta Meta) error {
+	if b.hooks.Set == nil {
+		return SetWithMeta(b.next, key, value, meta)
+	}
+	return ErrUnsupported
+}
+
+// GetMany returns the values for the given keys.
+func (b *intercepted) GetMany(keys []Key) (map[Key][]byte, error) {
+	if b.hooks.Get == nil {
+		return GetMany(b.next, keys)
+	}
+	return GetMany(core{b}, keys)
+}
+
+// DelMany deletes the given keys.
+func (b *intercepted) DelMany(keys []Key) error {
+	if b.hooks.Del == nil {
+		return DelMany(b.next, keys)
+	}
+	return DelMany(core{b}, keys)
+}
+
+// Stat returns information about the value for the given key from the next backing.
+func (b *intercepted) Stat(key Key) (Info, error) {
+	return Stat(b.next, key)
+}
+
+// Tag replaces the tags on the value for the given key in the next backing.
+func (b *intercepted) Tag(key Key, tags map[string]string) error {
+	return Tag(b.next, key, tags)
+}
@@ -0,0 +1,185 @@
+package backing_test
+
+import (
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	"github.com/mplewis/s3kv/logging"
+	"github.com/mplewis/s3kv/metrics"
+	"github.com/mplewis/s3kv/trace"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// native is a memory backing which also supports every optional operation natively.
+type native struct {
+	*backingtest.Memory
+}
+
+func (n native) GetStream(key backing.Key) (io.ReadCloser, error) {
+	n.Record("getstream " + key)
+	value, ok := n.Data[key]
+	if !ok {
+		return nil, nil
+	}
+	return ioutil.NopCloser(strings.NewReader(string(value))), nil
+}
+
+func (n native) SetStream(key backing.Key, r io.Reader) error {
+	n.Record("setstream " + key)
+	value, err := ioutil.ReadAll(r)
+	n.Data[key] = value
+	return err
+}
+
+func (n native) SetIfAbsent(key backing.Key, value []byte) (bool, error) {
+	n.Record("setifabsent " + key)
+	if _, ok := n.Data[key]; ok {
+		return false, nil
+	}
+	n.Data[key] = value
+	return true, nil
+}
+
+func (n native) GetMany(keys []backing.Key) (map[backing.Key][]byte, error) {
+	n.Record("getmany " + strings.Join(keys, ","))
+	values := map[backing.Key][]byte{}
+	for _, k := range keys {
+		if v, ok := n.Data[k]; ok {
+			values[k] = v
+		}
+	}
+	return values, nil
+}
+
+func (n native) DelMany(keys []backing.Key) error {
+	n.Record("delmany " + strings.Join(keys, ","))
+	for _, k := range keys {
+		delete(n.Data, k)
+	}
+	return nil
+}
+
+// upper is middleware which stores values in upper case and reads them back in lower case.
+var upper = backing.Intercept(backing.Interceptor{
+	Get: func(key backing.Key, next func(backing.Key) ([]byte, error)) ([]byte, error) {
+		v, err := next(key)
+		return []byte(strings.ToLower(string(v))), err
+	},
+	Set: func(key backing.Key, value []byte, next func(backing.Key, []byte) error) error {
+		return next(key, []byte(strings.ToUpper(string(value))))
+	},
+})
+
+var _ = Describe("Chain", func() {
+	It("applies middleware outermost first", func() {
+		var order []string
+		tag := func(name string) backing.Middleware {
+			return backing.Intercept(backing.Interceptor{
+				Set: func(key backing.Key, value []byte, next func(backing.Key, []byte) error) error {
+					order = append(order, name)
+					return next(key, value)
+				},
+			})
+		}
+		b := backing.Chain(backingtest.NewMemory(), tag("outer"), tag("inner"))
+		Expect(b.Set("k", nil)).To(Succeed())
+		Expect(order).To(Equal([]string{"outer", "inner"}))
+	})
+
+	It("passes optional operations through unhooked interceptors", func() {
+		n := native{backingtest.NewMemory()}
+		logged := backing.Intercept(backing.Interceptor{
+			Del: func(key backing.Key, next func(backing.Key) error) error { return next(key) },
+		})
+		b := backing.Chain(n, logged)
+
+		ok, err := backing.SetIfAbsent(b, "k", []byte("v"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+		ok, err = b.(backing.ConditionalWriter).SetIfAbsent("k", []byte("w"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeFalse())
+
+		r, err := backing.GetStream(b, "k")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.ReadAll(r)).To(Equal([]byte("v")))
+
+		values, err := backing.GetMany(b, []backing.Key{"k", "missing"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(Equal(map[backing.Key][]byte{"k": []byte("v")}))
+
+		// Del is hooked, so DelMany is emulated through the hook
+		Expect(backing.DelMany(b, []backing.Key{"k"})).To(Succeed())
+		Expect(n.Calls()).To(Equal([]string{"setifabsent k", "setifabsent k", "getstream k", "getmany k,missing", "del k"}))
+	})
+
+	It("emulates optional operations through hooks that transform values", func() {
+		n := native{backingtest.NewMemory()}
+		b := backing.Chain(n, upper)
+
+		Expect(backing.SetStream(b, "k", strings.NewReader("hello"))).To(Succeed())
+		Expect(n.Data["k"]).To(Equal([]byte("HELLO")))
+		r, err := b.(backing.Streamer).GetStream("k")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ioutil.ReadAll(r)).To(Equal([]byte("hello")))
+		values, err := backing.GetMany(b, []backing.Key{"k"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(values).To(HaveKeyWithValue("k", []byte("hello")))
+		Expect(n.Calls()).To(Equal([]string{"set k", "get k", "get k"}))
+
+		_, err = backing.SetIfAbsent(b, "other", []byte("x"))
+		Expect(err).To(MatchError(backing.ErrUnsupported))
+	})
+
+	It("emulates optional operations for plain middleware and backings", func() {
+		m := backingtest.NewMemory()
+		b := backing.Chain(m, func(next backing.Backing) backing.Backing { return next }, upper)
+
+		Expect(backing.SetStream(b, "a", strings.NewReader("x"))).To(Succeed())
+		Expect(backing.DelMany(b, []backing.Key{"a"})).To(Succeed())
+		Expect(m.Data).To(BeEmpty())
+
+		_, err := backing.SetIfAbsent(m, "k", nil)
+		Expect(err).To(MatchError(backing.ErrUnsupported))
+	})
+
+	It("reports which optional operations a chain really supports", func() {
+		plain := backing.Chain(backingtest.NewMemory(), backing.RetryMiddleware(backing.RetryArgs{}))
+		_, claimed := plain.(backing.MetaWriter)
+		Expect(claimed).To(BeTrue())
+		Expect(backing.Supports(plain, backing.Metadata)).To(BeFalse())
+		Expect(backing.Supports(plain, backing.ConditionalWrites)).To(BeFalse())
+
+		n := native{backingtest.NewMemory()}
+		Expect(backing.Supports(backing.Chain(n, backing.RetryMiddleware(backing.RetryArgs{})), backing.ConditionalWrites)).To(BeTrue())
+		Expect(backing.Supports(backing.Chain(n, upper), backing.ConditionalWrites)).To(BeFalse())
+		Expect(backing.Supports(backing.Chain(n, upper), backing.Batching)).To(BeFalse())
+		Expect(backing.Supports(n, backing.Batching)).To(BeTrue())
+	})
+
+	It("forwards optional operations through instrumentation, tracing and logging", func() {
+		n := native{backingtest.NewMemory()}
+		m := metrics.NewRegistry()
+		var entries []logging.Entry
+		b := backing.Chain(n,
+			backing.InstrumentMiddleware(m, "test"),
+			backing.TraceMiddleware(trace.NewRecorder()),
+			backing.LogMiddleware(logging.LoggerFunc(func(e logging.Entry) { entries = append(entries, e) }), "ns"),
+		)
+		Expect(backing.Supports(b, backing.Batching)).To(BeTrue())
+
+		Expect(backing.SetIfAbsent(b, "a", []byte("1"))).To(BeTrue())
+		Expect(backing.SetIfAbsent(b, "b", []byte("2"))).To(BeTrue())
+		Expect(backing.DelMany(b, []backing.Key{"a", "b"})).To(Succeed())
+		Expect(n.Calls()).To(Equal([]string{"setifabsent a", "setifabsent b", "delmany a,b"}))
+
+		Expect(m.Value(backing.MetricOperations, metrics.Labels{"backing": "test", "op": "del_many", "result": "ok"})).To(Equal(1.0))
+		Expect(entries).To(HaveLen(3))
+		Expect(entries[2].Op).To(Equal("DelMany"))
+		Expect(entries[2].Keys).To(Equal([]string{"a", "b"}))
+	})
+})
@@ -0,0 +1,222 @@
+package backing
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrVerifyFailed is returned by Migrate when the verification pass finds keys which differ between the backings.
+var ErrVerifyFailed = errors.New("migration verification failed")
+
+// MigrateArgs are the arguments for Migrate.
+type MigrateArgs struct {
+	Prefix       string                // Optional. Only migrate keys with this prefix.
+	Concurrency  int                   // Optional. How many keys to copy at once. Defaults to 8.
+	DryRun       bool                  // Optional. Report what would be copied without writing anything.
+	CompareETags bool                  // Optional. Skip keys whose ETags match without reading them. Only safe if both backings compute ETags the same way, such as two S3 buckets.
+	Verify       bool                  // Optional. After copying, read every key back from both backings and compare them.
+	Progress     func(MigrateProgress) // Optional. Called after each key is processed. Calls are serialized.
+}
+
+// Default values for MigrateArgs, if unset.
+const defaultMigrateConcurrency = 8
+
+// MigrateProgress describes how far a migration has got.
+type MigrateProgress struct {
+	Keys       int   // Keys found in the source.
+	Done       int   // Keys processed so far.
+	Copied     int   // Keys copied, or which would be copied in a dry run.
+	Skipped    int   // Keys which were already identical in the destination, or were deleted from the source.
+	Failed     int   // Keys which could not be copied.
+	Bytes      int64 // Value bytes copied.
+	Mismatched []Key // Keys which differed in the verification pass.
+	Extra      []Key // Keys which the verification pass found in the destination but not the source.
+}
+
+// Migrate copies every key with the given prefix from src to dst, skipping keys which are already identical, and
+// returns a summary. Keys which fail to copy don't stop the migration; the first error is returned once every key
+// has been tried. Metadata is copied if src implements Statter and dst implements MetaWriter.
+//
+// Migrate doesn't delete keys from dst which are missing from src, but the verification pass reports them in
+// Extra. To keep writes flowing to both backings while a migration runs, wrap the store's backing with DualWrite
+// first.
+//
+// Each key is copied only if dst still holds what Migrate first read from it, so a dual write which lands while a
+// key is being copied is not overwritten with the older value from src. A dual write between that last check and
+// the copy can still be lost, and a key deleted by a dual write while it is copied can reappear in dst. Run the
+// verification pass, and migrate again if it reports differences.
+func Migrate(src, dst Backing, args MigrateArgs) (MigrateProgress, error) {
+	if args.Concurrency <= 0 {
+		args.Concurrency = defaultMigrateConcurrency
+	}
+	keys, err := src.List(args.Prefix)
+	if err != nil {
+		return MigrateProgress{}, err
+	}
+	m := &migration{src: src, dst: dst, args: args, progress: MigrateProgress{Keys: len(keys)}}
+	m.each(keys, m.migrate)
+	if m.err != nil || !args.Verify || args.DryRun {
+		return m.progress, m.err
+	}
+
+	existing, err := dst.List(args.Prefix)
+	if err != nil {
+		return m.progress, err
+	}
+	listed := make(map[Key]bool, len(keys))
+	for _, key := range keys {
+		listed[key] = true
+	}
+	for _, key := range existing {
+		if !listed[key] {
+			keys = append(keys, key)
+		}
+	}
+	m.each(keys, m.verify)
+	if m.err == nil && len(m.progress.Mismatched)+len(m.progress.Extra) > 0 {
+		m.err = fmt.Errorf("%w: %d keys differ and %d are only in the destination", ErrVerifyFailed, len(m.progress.Mismatched), len(m.progress.Extra))
+	}
+	return m.progress, m.err
+}
+
+// migration tracks the state of a single call to Migrate.
+type migration struct {
+	src, dst Backing
+	args     MigrateArgs
+
+	access   sync.Mutex
+	progress MigrateProgress
+	err      error
+}
+
+// each runs fn on every key using the configured concurrency.
+func (m *migration) each(keys []Key, fn func(key Key)) {
+	work := make(chan Key)
+	wg := sync.WaitGroup{}
+	for i := 0; i < m.args.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				fn(key)
+			}
+		}()
+	}
+	for _, key := range keys {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+}
+
+// update applies a change to the progress and reports it.
+func (m *migration) update(fn func(p *MigrateProgress)) {
+	m.access.Lock()
+	defer m.access.Unlock()
+	fn(&m.progress)
+	if m.args.Progress != nil {
+		m.args.Progress(m.progress)
+	}
+}
+
+// migrate copies a single key and records the outcome.
+func (m *migration) migrate(key Key) {
+	copied, size, err := m.copy(key)
+	if err != nil {
+		m.access.Lock()
+		if m.err == nil {
+			m.err = fmt.Errorf("migrating %s: %w", key, err)
+		}
+		m.access.Unlock()
+	}
+	m.update(func(p *MigrateProgress) {
+		p.Done++
+		switch {
+		case err != nil:
+			p.Failed++
+		case copied:
+			p.Copied++
+			p.Bytes += int64(size)
+		default:
+			p.Skipped++
+		}
+	})
+}
+
+// copy copies a single key unless it is already identical in dst, or dst has changed since it was first read. It
+// returns whether the key was copied and the size of its value.
+func (m *migration) copy(key Key) (bool, int, error) {
+	info, err := Stat(m.src, key)
+	if err != nil && !errors.Is(err, ErrUnsupported) {
+		return false, 0, err
+	}
+	if m.args.CompareETags && info.Exists && info.ETag != "" {
+		dstInfo, err := Stat(m.dst, key)
+		if err != nil && !errors.Is(err, ErrUnsupported) {
+			return false, 0, err
+		}
+		if dstInfo.Exists && dstInfo.ETag == info.ETag && dstInfo.Size == info.Size {
+			return false, 0, nil
+		}
+	}
+
+	// read dst first, so a dual write which lands after src is read shows up as a change to dst
+	current, err := m.dst.Get(key)
+	if err != nil {
+		return false, 0, err
+	}
+	value, err := m.src.Get(key)
+	if err != nil || value == nil {
+		return false, 0, err
+	}
+	if current != nil && sha256.Sum256(current) == sha256.Sum256(value) {
+		return false, 0, nil
+	}
+	if m.args.DryRun {
+		return true, len(value), nil
+	}
+	latest, err := m.dst.Get(key)
+	if err != nil {
+		return false, 0, err
+	}
+	if !bytes.Equal(latest, current) {
+		return false, 0, nil
+	}
+	if !info.Meta.IsZero() {
+		err := SetWithMeta(m.dst, key, value, info.Meta)
+		if !errors.Is(err, ErrUnsupported) {
+			return err == nil, len(value), err
+		}
+	}
+	return true, len(value), m.dst.Set(key, value)
+}
+
+// verify compares a single key in both backings and records it if they differ or it is only in dst.
+func (m *migration) verify(key Key) {
+	value, err := m.src.Get(key)
+	var current []byte
+	if err == nil {
+		current, err = m.dst.Get(key)
+	}
+	if err != nil {
+		m.access.Lock()
+		if m.err == nil {
+			m.err = fmt.Errorf("verifying %s: %w", key, err)
+		}
+		m.access.Unlock()
+		return
+	}
+	switch {
+	case value == nil && current != nil:
+		m.update(func(p *MigrateProgress) {
+			p.Extra = append(p.Extra, key)
+		})
+	case value != nil && !bytes.Equal(value, current):
+		m.update(func(p *MigrateProgress) {
+			p.Mismatched = append(p.Mismatched, key)
+		})
+	}
+}
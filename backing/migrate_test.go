@@ -0,0 +1,150 @@
+package backing_test
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Migrate", func() {
+	var src, dst *backingtest.Memory
+	BeforeEach(func() {
+		src, dst = backingtest.NewMemory(), backingtest.NewMemory()
+		for i := 0; i < 20; i++ {
+			src.Data[fmt.Sprintf("ns/%02d", i)] = []byte(fmt.Sprintf("value %d", i))
+		}
+		src.Data["other/x"] = []byte("x")
+		dst.Data["ns/00"] = []byte("value 0")
+		dst.Data["ns/01"] = []byte("stale")
+	})
+
+	It("copies keys which differ and skips identical ones", func() {
+		var reports int
+		p, err := backing.Migrate(src, dst, backing.MigrateArgs{
+			Prefix:      "ns/",
+			Concurrency: 4,
+			Verify:      true,
+			Progress:    func(backing.MigrateProgress) { reports++ },
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Keys).To(Equal(20))
+		Expect(p.Done).To(Equal(20))
+		Expect(p.Copied).To(Equal(19))
+		Expect(p.Skipped).To(Equal(1))
+		Expect(p.Mismatched).To(BeEmpty())
+		Expect(reports).To(Equal(20))
+		Expect(dst.Data).To(HaveLen(20))
+		Expect(dst.Data["ns/01"]).To(Equal([]byte("value 1")))
+	})
+
+	It("writes nothing in a dry run", func() {
+		p, err := backing.Migrate(src, dst, backing.MigrateArgs{Prefix: "ns/", DryRun: true, Verify: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Copied).To(Equal(19))
+		Expect(dst.Data).To(HaveLen(2))
+		Expect(dst.Data["ns/01"]).To(Equal([]byte("stale")))
+	})
+
+	It("trusts matching ETags only when asked to", func() {
+		a, b := newVersioned(), newVersioned()
+		Expect(a.Set("k", []byte("new"))).To(Succeed())
+		Expect(b.Set("k", []byte("old"))).To(Succeed())
+
+		p, err := backing.Migrate(a, b, backing.MigrateArgs{CompareETags: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Skipped).To(Equal(1))
+		Expect(b.Data["k"]).To(Equal([]byte("old")))
+
+		p, err = backing.Migrate(a, b, backing.MigrateArgs{})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Copied).To(Equal(1))
+		Expect(b.Data["k"]).To(Equal([]byte("new")))
+	})
+
+	It("reports failures and mismatches", func() {
+		p, err := backing.Migrate(src, failing{}, backing.MigrateArgs{Prefix: "ns/0"})
+		Expect(errors.Is(err, errBoom)).To(BeTrue())
+		Expect(p.Failed).To(Equal(10))
+
+		racing := backing.Intercept(backing.Interceptor{
+			Set: func(key backing.Key, value []byte, next func(backing.Key, []byte) error) error {
+				return next(key, append(value, '!'))
+			},
+		})(dst)
+		p, err = backing.Migrate(src, racing, backing.MigrateArgs{Prefix: "ns/1", Verify: true})
+		Expect(errors.Is(err, backing.ErrVerifyFailed)).To(BeTrue())
+		Expect(p.Mismatched).To(HaveLen(10))
+	})
+
+	It("reports keys which are only in the destination", func() {
+		dst.Data["ns/gone"] = []byte("deleted from the source")
+		p, err := backing.Migrate(src, dst, backing.MigrateArgs{Prefix: "ns/", Verify: true})
+		Expect(errors.Is(err, backing.ErrVerifyFailed)).To(BeTrue())
+		Expect(p.Mismatched).To(BeEmpty())
+		Expect(p.Extra).To(Equal([]backing.Key{"ns/gone"}))
+	})
+
+	It("doesn't overwrite a dual write which lands while a key is copied", func() {
+		dual := backing.DualWrite(src, dst, backing.DualWriteArgs{})
+		racing := backing.Intercept(backing.Interceptor{
+			Get: func(key backing.Key, next func(backing.Key) ([]byte, error)) ([]byte, error) {
+				value, err := next(key)
+				if key == "ns/05" {
+					Expect(dual.Set(key, []byte("newer"))).To(Succeed())
+				}
+				return value, err
+			},
+		})(src)
+
+		_, err := backing.Migrate(racing, dst, backing.MigrateArgs{Prefix: "ns/", Concurrency: 1})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(dst.Data["ns/05"]).To(Equal([]byte("newer")))
+		Expect(dst.Data["ns/06"]).To(Equal([]byte("value 6")))
+	})
+})
+
+var _ = Describe("DualWrite", func() {
+	It("reads from the primary and writes to both", func() {
+		primary, secondary := backingtest.NewMemory(), backingtest.NewMemory()
+		secondary.Data["only"] = []byte("secondary")
+		b := backing.DualWrite(primary, secondary, backing.DualWriteArgs{})
+
+		Expect(b.Set("k", []byte("v"))).To(Succeed())
+		Expect(primary.Data["k"]).To(Equal([]byte("v")))
+		Expect(secondary.Data["k"]).To(Equal([]byte("v")))
+		Expect(b.Get("only")).To(BeNil())
+
+		Expect(backing.SetWithMeta(b, "m", []byte("v"), backing.Meta{ContentType: "text/plain"})).To(MatchError(backing.ErrUnsupported))
+		Expect(b.Del("k")).To(Succeed())
+		Expect(primary.Data).NotTo(HaveKey("k"))
+		Expect(secondary.Data).NotTo(HaveKey("k"))
+
+		Expect(b.Set("a", []byte("1"))).To(Succeed())
+		Expect(b.Set("b", []byte("2"))).To(Succeed())
+		Expect(backing.GetMany(b, []backing.Key{"a", "b", "only"})).To(Equal(map[backing.Key][]byte{"a": []byte("1"), "b": []byte("2")}))
+		Expect(backing.DelMany(b, []backing.Key{"a", "b"})).To(Succeed())
+		Expect(primary.Data).To(BeEmpty())
+		Expect(secondary.Data).To(HaveLen(1))
+		Expect(backing.Tag(b, "only", map[string]string{"t": "1"})).To(MatchError(backing.ErrUnsupported))
+	})
+
+	It("fails or reports secondary errors", func() {
+		primary := backingtest.NewMemory()
+		Expect(backing.DualWrite(primary, failing{}, backing.DualWriteArgs{}).Set("k", []byte("v"))).To(MatchError(errBoom))
+		Expect(primary.Data["k"]).To(Equal([]byte("v")))
+
+		var reported []string
+		b := backing.DualWrite(primary, failing{}, backing.DualWriteArgs{
+			OnSecondaryError: func(op string, key backing.Key, err error) { reported = append(reported, op+" "+key) },
+		})
+		Expect(b.Set("k", []byte("v"))).To(Succeed())
+		Expect(b.Del("k")).To(Succeed())
+		Expect(backing.DelMany(b, []backing.Key{"x", "y"})).To(Succeed())
+		Expect(reported).To(Equal([]string{"Set k", "Del k", "DelMany x", "DelMany y"}))
+		Expect(backing.DualWrite(failing{}, primary, backing.DualWriteArgs{}).Set("k", []byte("v"))).To(MatchError(errBoom))
+	})
+})
@@ -0,0 +1,195 @@
+package backing
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// ErrUnsupported is returned when a backing does not support an optional operation and it cannot be emulated.
+var ErrUnsupported = errors.New("operation not supported by backing")
+
+// Streamer is implemented by backings which can read and write values without buffering them in memory.
+type Streamer interface {
+	// GetStream returns a reader for the value of the given key, or nil if it does not exist. The caller must close
+	// it.
+	GetStream(key Key) (io.ReadCloser, error)
+	// SetStream sets the value for the given key from a reader.
+	SetStream(key Key, r io.Reader) error
+}
+
+// ConditionalWriter is implemented by backings which can atomically create a key only if it does not exist.
+type ConditionalWriter interface {
+	// SetIfAbsent sets the value for the given key only if the key does not already exist. It returns false if the
+	// key already existed and nothing was written.
+	SetIfAbsent(key Key, value []byte) (bool, error)
+}
+
+// Batcher is implemented by backings which can operate on many keys in one request.
+type Batcher interface {
+	// GetMany returns the values for the given keys. Keys that don't exist are omitted from the result.
+	GetMany(keys []Key) (map[Key][]byte, error)
+	// DelMany deletes the key-value pairs for the given keys.
+	DelMany(keys []Key) error
+}
+
+// Info describes a stored value without its contents.
+type Info struct {
+	Exists       bool      // False if there is no value for the key, in which case the other fields are empty.
+	Size         int64     // The size of the value as stored, which may differ from its size to callers of a wrapper.
+	ETag         string    // An opaque identifier which changes whenever the value does.
+	LastModified time.Time // When the value was last written.
+	Meta         Meta      // Metadata written with the value, if the backing stores it.
+	Version      string    // The ID of the current version, if the backing keeps versions.
+}
+
+// Statter is implemented by backings which can describe a value without reading it, such as with an S3 HEAD.
+type Statter interface {
+	// Stat returns information about the value for the given key.
+	Stat(key Key) (Info, error)
+}
+
+// Tagger is implemented by backings which can attach tags to a stored value, such as S3 object tags used by
+// lifecycle rules.
+type Tagger interface {
+	// Tag replaces the tags on the value for the given key.
+	Tag(key Key, tags map[string]string) error
+}
+
+// Capability is an optional operation which a backing may support.
+type Capability int
+
+const (
+	Streaming         Capability = iota // Streamer
+	ConditionalWrites                   // ConditionalWriter
+	Batching                            // Batcher
+	Stats                               // Statter
+	Tagging                             // Tagger
+	Metadata                            // MetaWriter
+	Versioning                          // Versioner
+)
+
+// Capable is implemented by wrappers whose optional methods depend on the backing they wrap, and by backings whose
+// support depends on how they are configured, so their method set alone doesn't say what they can do.
+type Capable interface {
+	// Supports returns true if the optional operation is performed natively, rather than emulated or rejected with
+	// ErrUnsupported.
+	Supports(c Capability) bool
+}
+
+// Supports returns true if the backing natively supports the given optional operation. Wrappers such as the layers
+// of a Chain implement every optional interface and emulate or reject what the backing beneath them can't do, so
+// use Supports rather than a type assertion to decide whether to rely on an operation.
+func Supports(b Backing, c Capability) bool {
+	if cb, ok := b.(Capable); ok {
+		return cb.Supports(c)
+	}
+	return implements(b, c)
+}
+
+// implements returns true if the backing has the methods of the given optional operation.
+func implements(b Backing, c Capability) bool {
+	var ok bool
+	switch c {
+	case Streaming:
+		_, ok = b.(Streamer)
+	case ConditionalWrites:
+		_, ok = b.(ConditionalWriter)
+	case Batching:
+		_, ok = b.(Batcher)
+	case Stats:
+		_, ok = b.(Statter)
+	case Tagging:
+		_, ok = b.(Tagger)
+	case Metadata:
+		_, ok = b.(MetaWriter)
+	case Versioning:
+		_, ok = b.(Versioner)
+	}
+	return ok
+}
+
+// GetStream returns a reader for the value of the given key, or nil if it does not exist, streaming if the backing
+// supports it and buffering the whole value otherwise.
+func GetStream(b Backing, key Key) (io.ReadCloser, error) {
+	if s, ok := b.(Streamer); ok {
+		return s.GetStream(key)
+	}
+	value, err := b.Get(key)
+	if err != nil || value == nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(value)), nil
+}
+
+// SetStream sets the value for the given key from a reader, streaming if the backing supports it and buffering
+// the whole value otherwise.
+func SetStream(b Backing, key Key, r io.Reader) error {
+	if s, ok := b.(Streamer); ok {
+		return s.SetStream(key, r)
+	}
+	value, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return b.Set(key, value)
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist. Conditional writes can't be
+// emulated safely, so this returns ErrUnsupported if the backing does not implement ConditionalWriter.
+func SetIfAbsent(b Backing, key Key, value []byte) (bool, error) {
+	if c, ok := b.(ConditionalWriter); ok {
+		return c.SetIfAbsent(key, value)
+	}
+	return false, ErrUnsupported
+}
+
+// GetMany returns the values for the given keys, in one batch if the backing supports it and one at a time
+// otherwise. Keys that don't exist are omitted from the result.
+func GetMany(b Backing, keys []Key) (map[Key][]byte, error) {
+	if m, ok := b.(Batcher); ok {
+		return m.GetMany(keys)
+	}
+	values := map[Key][]byte{}
+	for _, key := range keys {
+		value, err := b.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// DelMany deletes the given keys, in one batch if the backing supports it and one at a time otherwise.
+func DelMany(b Backing, keys []Key) error {
+	if m, ok := b.(Batcher); ok {
+		return m.DelMany(keys)
+	}
+	for _, key := range keys {
+		if err := b.Del(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Stat returns information about the value for the given key, or ErrUnsupported if the backing is not a Statter.
+func Stat(b Backing, key Key) (Info, error) {
+	if st, ok := b.(Statter); ok {
+		return st.Stat(key)
+	}
+	return Info{}, ErrUnsupported
+}
+
+// Tag replaces the tags on the value for the given key, or returns ErrUnsupported if the backing is not a Tagger.
+func Tag(b Backing, key Key, tags map[string]string) error {
+	if t, ok := b.(Tagger); ok {
+		return t.Tag(key, tags)
+	}
+	return ErrUnsupported
+}
@@ -0,0 +1,302 @@
+package backing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Names of backing operations, as passed to retry hooks and used to look up per-operation retry policies.
+const (
+	OpList        = "List"
+	OpGet         = "Get"
+	OpSet         = "Set"
+	OpDel         = "Del"
+	OpGetStream   = "GetStream"
+	OpSetStream   = "SetStream"
+	OpSetIfAbsent = "SetIfAbsent"
+	OpGetMany     = "GetMany"
+	OpDelMany     = "DelMany"
+)
+
+// Retryability classifies an error by whether the failed operation may be retried.
+type Retryability int
+
+const (
+	// RetryNever means the error is permanent, such as access denied or a cancelled context.
+	RetryNever Retryability = iota
+	// RetryIfIdempotent means the request may or may not have taken effect, such as after a timeout or a 500, so
+	// only operations which are safe to repeat are retried.
+	RetryIfIdempotent
+	// RetryAlways means the request was rejected before it took effect, such as S3 throttling with SlowDown or a
+	// 503, so any operation may be retried.
+	RetryAlways
+)
+
+// throttleCodes are S3 and AWS error codes which mean a request was rejected without taking effect.
+var throttleCodes = map[string]bool{
+	"SlowDown":                 true,
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+	"ServiceUnavailable":       true,
+	"RequestThrottled":         true,
+}
+
+// transientCodes are S3 and AWS error codes for failures which may have taken effect.
+var transientCodes = map[string]bool{
+	"InternalError":  true,
+	"RequestTimeout": true,
+}
+
+// Classify is the default retry classification. It understands AWS SDK API errors, HTTP status codes and
+// network errors, without depending on the SDK's error types.
+func Classify(err error) Retryability {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return RetryNever
+	}
+
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		if throttleCodes[coded.ErrorCode()] {
+			return RetryAlways
+		}
+		if transientCodes[coded.ErrorCode()] {
+			return RetryIfIdempotent
+		}
+	}
+
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) {
+		switch code := status.HTTPStatusCode(); {
+		case code == 429 || code == 503:
+			return RetryAlways
+		case code >= 500:
+			return RetryIfIdempotent
+		case code > 0:
+			return RetryNever
+		}
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return RetryAlways // the connection was never made
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return RetryIfIdempotent
+	}
+	return RetryNever
+}
+
+// RetryPolicy limits how hard a single operation is retried.
+type RetryPolicy struct {
+	Attempts int           // Maximum attempts, including the first. Values below 1 mean a single attempt.
+	Budget   time.Duration // Maximum total time to spend, including backoff. Zero means no limit.
+}
+
+// RetryArgs are the arguments for a retrying backing.
+type RetryArgs struct {
+	Policy    RetryPolicy                                      // Optional. The default policy. Defaults to 4 attempts and no time budget.
+	PerOp     map[string]RetryPolicy                           // Optional. Policies for specific operations, keyed by Op name, overriding Policy.
+	BaseDelay time.Duration                                    // Optional. The backoff before the first retry, doubled each attempt. Defaults to 50 ms.
+	MaxDelay  time.Duration                                    // Optional. The longest backoff between attempts. Defaults to 5 s.
+	Classify  func(err error) Retryability                     // Optional. Decides which errors are retried. Defaults to Classify.
+	OnRetry   func(op string, key Key, attempt int, err error) // Optional. Called before each retry, for counting or logging retries.
+}
+
+// Default values for RetryArgs, if unset.
+const (
+	defaultRetryAttempts  = 4
+	defaultRetryBaseDelay = 50 * time.Millisecond
+	defaultRetryMaxDelay  = 5 * time.Second
+)
+
+// retrying is a Backing which retries failed operations on another Backing.
+type retrying struct {
+	next Backing
+	args RetryArgs
+}
+
+// Retry wraps a backing so that transient failures are retried with exponential backoff and full jitter.
+//
+// Get, List, Set and Del are idempotent when used through a Store, since Set overwrites the whole value and Del
+// succeeds for missing keys, so they are retried on any retryable error. SetIfAbsent is not idempotent, since a
+// lost response could make a successful write look like a conflict on retry, so it is only retried when the error
+// shows the request was rejected outright. SetStream is only retried when its reader can be rewound.
+func Retry(b Backing, args RetryArgs) Backing {
+	if args.Policy.Attempts == 0 {
+		args.Policy.Attempts = defaultRetryAttempts
+	}
+	if args.BaseDelay == 0 {
+		args.BaseDelay = defaultRetryBaseDelay
+	}
+	if args.MaxDelay == 0 {
+		args.MaxDelay = defaultRetryMaxDelay
+	}
+	if args.Classify == nil {
+		args.Classify = Classify
+	}
+	return &retrying{next: b, args: args}
+}
+
+// RetryMiddleware returns middleware which wraps a backing with Retry.
+func RetryMiddleware(args RetryArgs) Middleware {
+	return func(next Backing) Backing {
+		return Retry(next, args)
+	}
+}
+
+// policy returns the retry policy for an operation.
+func (r *retrying) policy(op string) RetryPolicy {
+	if p, ok := r.args.PerOp[op]; ok {
+		return p
+	}
+	return r.args.Policy
+}
+
+// backoff returns the delay before the given retry, with full jitter.
+func (r *retrying) backoff(retry int) time.Duration {
+	ceiling := r.args.BaseDelay << uint(retry)
+	if ceiling <= 0 || ceiling > r.args.MaxDelay {
+		ceiling = r.args.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(ceiling) + 1))
+}
+
+// describe names an operation and its key, if it has one, for error messages.
+func describe(op string, key Key) string {
+	if key == "" {
+		return op
+	}
+	return op + " " + key
+}
+
+// do runs fn until it succeeds, fails permanently, or the operation's policy is exhausted. Idempotent operations
+// are retried on ambiguous errors as well as rejections.
+func (r *retrying) do(op string, key Key, idempotent bool, fn func() error) error {
+	policy := r.policy(op)
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+
+		class := r.args.Classify(err)
+		if class == RetryNever || (class == RetryIfIdempotent && !idempotent) {
+			return err
+		}
+		if attempt >= policy.Attempts {
+			if attempt > 1 {
+				return fmt.Errorf("%s failed after %d attempts: %w", describe(op, key), attempt, err)
+			}
+			return err
+		}
+		delay := r.backoff(attempt - 1)
+		if policy.Budget > 0 && time.Since(start)+delay > policy.Budget {
+			return fmt.Errorf("%s exhausted its retry budget after %d attempts: %w", describe(op, key), attempt, err)
+		}
+
+		if r.args.OnRetry != nil {
+			r.args.OnRetry(op, key, attempt, err)
+		}
+		<-time.After(delay)
+	}
+}
+
+// List lists all keys in the store with the given prefix.
+func (r *retrying) List(prefix string) (keys []Key, err error) {
+	err = r.do(OpList, prefix, true, func() (err error) {
+		keys, err = r.next.List(prefix)
+		return err
+	})
+	return keys, err
+}
+
+// Get returns the value for the given key.
+func (r *retrying) Get(key Key) (value []byte, err error) {
+	err = r.do(OpGet, key, true, func() (err error) {
+		value, err = r.next.Get(key)
+		return err
+	})
+	return value, err
+}
+
+// Set sets the value for the given key.
+func (r *retrying) Set(key Key, value []byte) error {
+	return r.do(OpSet, key, true, func() error {
+		return r.next.Set(key, value)
+	})
+}
+
+// Del deletes the key-value pair for the given key.
+func (r *retrying) Del(key Key) error {
+	return r.do(OpDel, key, true, func() error {
+		return r.next.Del(key)
+	})
+}
+
+// GetStream returns a reader for the value of the given key. Only opening the stream is retried.
+func (r *retrying) GetStream(key Key) (rc io.ReadCloser, err error) {
+	err = r.do(OpGetStream, key, true, func() (err error) {
+		rc, err = GetStream(r.next, key)
+		return err
+	})
+	return rc, err
+}
+
+// SetStream sets the value for the given key from a reader. It is only retried if the reader is an io.Seeker, since
+// otherwise the first attempt consumes it.
+func (r *retrying) SetStream(key Key, body io.Reader) error {
+	seeker, rewindable := body.(io.Seeker)
+	if !rewindable {
+		return SetStream(r.next, key, body)
+	}
+	first := true
+	return r.do(OpSetStream, key, true, func() error {
+		if !first {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		}
+		first = false
+		return SetStream(r.next, key, body)
+	})
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist.
+func (r *retrying) SetIfAbsent(key Key, value []byte) (ok bool, err error) {
+	err = r.do(OpSetIfAbsent, key, false, func() (err error) {
+		ok, err = SetIfAbsent(r.next, key, value)
+		return err
+	})
+	return ok, err
+}
+
+// GetMany returns the values for the given keys.
+func (r *retrying) GetMany(keys []Key) (values map[Key][]byte, err error) {
+	err = r.do(OpGetMany, "", true, func() (err error) {
+		values, err = GetMany(r.next, keys)
+		return err
+	})
+	return values, err
+}
+
+// DelMany deletes the given keys.
+func (r *retrying) DelMany(keys []Key) error {
+	return r.do(OpDelMany, "", true, func() error {
+		return DelMany(r.next, keys)
+	})
+}
+
+// Supports returns true if the next backing natively supports the given optional operation and the retrying
+// backing forwards it.
+func (r *retrying) Supports(c Capability) bool {
+	return (c == Streaming || c == ConditionalWrites || c == Batching) && Supports(r.next, c)
+}
@@ -0,0 +1,147 @@
+package backing_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// apiError mimics an AWS SDK API error with an error code and HTTP status.
+type apiError struct {
+	code   string
+	status int
+}
+
+func (e apiError) Error() string       { return fmt.Sprintf("api error %s (%d)", e.code, e.status) }
+func (e apiError) ErrorCode() string   { return e.code }
+func (e apiError) HTTPStatusCode() int { return e.status }
+
+var slowDown = apiError{code: "SlowDown", status: 503}
+var internal = apiError{code: "InternalError", status: 500}
+var denied = apiError{code: "AccessDenied", status: 403}
+
+// flaky is a backing which fails each operation with queued errors before succeeding.
+type flaky struct {
+	*backingtest.Memory
+	errs []error
+}
+
+func (f *flaky) fail() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+	err := f.errs[0]
+	f.errs = f.errs[1:]
+	return err
+}
+
+func (f *flaky) Get(key backing.Key) ([]byte, error) {
+	if err := f.fail(); err != nil {
+		return nil, err
+	}
+	return f.Memory.Get(key)
+}
+
+func (f *flaky) Set(key backing.Key, value []byte) error {
+	if err := f.fail(); err != nil {
+		return err
+	}
+	return f.Memory.Set(key, value)
+}
+
+func (f *flaky) SetIfAbsent(key backing.Key, value []byte) (bool, error) {
+	if err := f.fail(); err != nil {
+		return false, err
+	}
+	if _, ok := f.Data[key]; ok {
+		return false, nil
+	}
+	f.Data[key] = value
+	return true, nil
+}
+
+var _ = Describe("Retry", func() {
+	fast := backing.RetryArgs{BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+
+	It("classifies errors", func() {
+		Expect(backing.Classify(slowDown)).To(Equal(backing.RetryAlways))
+		Expect(backing.Classify(fmt.Errorf("wrapped: %w", slowDown))).To(Equal(backing.RetryAlways))
+		Expect(backing.Classify(apiError{status: 429})).To(Equal(backing.RetryAlways))
+		Expect(backing.Classify(internal)).To(Equal(backing.RetryIfIdempotent))
+		Expect(backing.Classify(apiError{status: 502})).To(Equal(backing.RetryIfIdempotent))
+		Expect(backing.Classify(denied)).To(Equal(backing.RetryNever))
+		Expect(backing.Classify(&net.OpError{Op: "dial", Err: errBoom})).To(Equal(backing.RetryAlways))
+		Expect(backing.Classify(&net.OpError{Op: "read", Err: errBoom})).To(Equal(backing.RetryIfIdempotent))
+		Expect(backing.Classify(context.Canceled)).To(Equal(backing.RetryNever))
+		Expect(backing.Classify(errBoom)).To(Equal(backing.RetryNever))
+	})
+
+	It("retries transient failures and reports each retry", func() {
+		f := &flaky{Memory: backingtest.NewMemory(), errs: []error{slowDown, internal}}
+		var retries []string
+		args := fast
+		args.OnRetry = func(op string, key backing.Key, attempt int, err error) {
+			retries = append(retries, fmt.Sprintf("%s %s %d %s", op, key, attempt, err))
+		}
+		b := backing.Retry(f, args)
+
+		Expect(b.Set("k", []byte("v"))).To(Succeed())
+		Expect(retries).To(Equal([]string{
+			"Set k 1 api error SlowDown (503)",
+			"Set k 2 api error InternalError (500)",
+		}))
+		Expect(f.Data["k"]).To(Equal([]byte("v")))
+	})
+
+	It("does not retry permanent failures", func() {
+		f := &flaky{Memory: backingtest.NewMemory(), errs: []error{denied}}
+		_, err := backing.Retry(f, fast).Get("k")
+		Expect(err).To(Equal(denied))
+	})
+
+	It("gives up after the per-operation attempt limit", func() {
+		f := &flaky{Memory: backingtest.NewMemory(), errs: []error{slowDown, slowDown, slowDown, slowDown}}
+		args := fast
+		args.PerOp = map[string]backing.RetryPolicy{backing.OpGet: {Attempts: 2}}
+		_, err := backing.Retry(f, args).Get("k")
+		Expect(err).To(MatchError("Get k failed after 2 attempts: api error SlowDown (503)"))
+		Expect(errors.Is(err, slowDown)).To(BeTrue())
+		Expect(f.errs).To(HaveLen(2))
+	})
+
+	It("gives up when the time budget runs out", func() {
+		f := &flaky{Memory: backingtest.NewMemory(), errs: []error{slowDown, slowDown, slowDown}}
+		args := backing.RetryArgs{
+			Policy:    backing.RetryPolicy{Attempts: 10, Budget: time.Nanosecond},
+			BaseDelay: time.Millisecond,
+		}
+		err := backing.Retry(f, args).Set("k", nil)
+		Expect(err.Error()).To(HavePrefix("Set k exhausted its retry budget after 1 attempts"))
+	})
+
+	It("only retries conditional writes which were rejected outright", func() {
+		f := &flaky{Memory: backingtest.NewMemory(), errs: []error{internal}}
+		_, err := backing.Retry(f, fast).(backing.ConditionalWriter).SetIfAbsent("k", nil)
+		Expect(err).To(Equal(internal))
+
+		f.errs = []error{slowDown}
+		ok, err := backing.SetIfAbsent(backing.Retry(f, fast), "k", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(ok).To(BeTrue())
+	})
+
+	It("rewinds seekable streams between attempts", func() {
+		f := &flaky{Memory: backingtest.NewMemory(), errs: []error{slowDown}}
+		b := backing.Retry(f, fast)
+		Expect(backing.SetStream(b, "k", strings.NewReader("hello"))).To(Succeed())
+		Expect(f.Data["k"]).To(Equal([]byte("hello")))
+	})
+})
@@ -3,20 +3,31 @@ package backing
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"sort"
+	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
+// maxDeleteBatch is the most keys S3 will delete in a single DeleteObjects request.
+const maxDeleteBatch = 1000
+
 // S3 stores data in AWS S3.
 type S3 struct {
 	bucket    string
 	namespace string
 	client    *s3.Client
 	context   context.Context
+	versioned bool
 }
 
 // S3Args are the arguments for creating a new S3 backing.
@@ -44,9 +55,27 @@ func NewS3(args S3Args) (Backing, error) {
 		context:   args.Context,
 		bucket:    args.Bucket,
 		namespace: args.Namespace,
+		versioned: versioningEnabled(args.Context, args.Client, args.Bucket),
 	}, nil
 }
 
+// versioningEnabled returns true if the bucket has versioning enabled. Suspended versioning doesn't keep new
+// versions, and a failed check, such as for a client without s3:GetBucketVersioning, counts as not enabled, so that
+// callers fall back to keeping their own history.
+func versioningEnabled(ctx context.Context, client *s3.Client, bucket string) bool {
+	out, err := client.GetBucketVersioning(ctx, &s3.GetBucketVersioningInput{Bucket: aws.String(bucket)})
+	return err == nil && out.Status == types.BucketVersioningStatusEnabled
+}
+
+// Supports returns true if the backing natively supports the given optional operation. Versioning is only supported
+// if the bucket had versioning enabled when the backing was created.
+func (s *S3) Supports(c Capability) bool {
+	if c == Versioning {
+		return s.versioned
+	}
+	return implements(s, c)
+}
+
 // ns appends the namespace prefix to the given key.
 func (s *S3) ns(key Key) Key {
 	return fmt.Sprintf("%s/%s", s.namespace, key)
@@ -55,46 +84,253 @@ func (s *S3) ns(key Key) Key {
 // List lists all keys in the store with the given prefix. This is likely a very slow operation, so use with caution.
 func (s *S3) List(prefix string) ([]Key, error) {
 	var keys []Key
-	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &prefix})
+	full := s.ns(prefix)
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: &full})
 	for paginator.HasMorePages() {
 		output, err := paginator.NextPage(s.context)
 		if err != nil {
 			return nil, err
 		}
 		for _, c := range output.Contents {
-			keys = append(keys, Key(*c.Key))
+			keys = append(keys, strings.TrimPrefix(*c.Key, s.ns("")))
 		}
 	}
 	return keys, nil
 }
 
-// Get returns the value for the given key.
+// isNotFound returns true if the error from S3 means the object does not exist.
+func isNotFound(err error) bool {
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) {
+		code := coded.ErrorCode()
+		return code == "NoSuchKey" || code == "NotFound"
+	}
+	return false
+}
+
+// Get returns the value for the given key, or nil if it does not exist.
 func (s *S3) Get(key Key) ([]byte, error) {
 	r, err := s.client.GetObject(s.context, &s3.GetObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(s.ns(key)),
 	})
+	if isNotFound(err) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
+	defer r.Body.Close()
 	return ioutil.ReadAll(r.Body)
 }
 
-// Set sets the value for the given key.
+// Set sets the value for the given key. The request carries a Content-MD5 of the value so S3 rejects it if the
+// bytes are corrupted in transit.
 func (s *S3) Set(key Key, value []byte) error {
+	return s.SetWithMeta(key, value, Meta{})
+}
+
+// SetWithMeta sets the value for the given key, storing the metadata as S3 object headers.
+func (s *S3) SetWithMeta(key Key, value []byte, meta Meta) error {
+	digest := md5.Sum(value)
+	input := &s3.PutObjectInput{
+		Bucket:     aws.String(s.bucket),
+		Key:        aws.String(s.ns(key)),
+		Body:       bytes.NewReader(value),
+		ContentMD5: aws.String(base64.StdEncoding.EncodeToString(digest[:])),
+		Metadata:   meta.User,
+	}
+	if meta.ContentType != "" {
+		input.ContentType = aws.String(meta.ContentType)
+	}
+	if meta.CacheControl != "" {
+		input.CacheControl = aws.String(meta.CacheControl)
+	}
+	_, err := s.client.PutObject(s.context, input)
+	return err
+}
+
+// Del deletes the key-value pair for the given key.
+func (s *S3) Del(key Key) error {
+	_, err := s.client.DeleteObject(s.context, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.ns(key)),
+	})
+	return err
+}
+
+// GetStream returns a reader for the value of the given key, or nil if it does not exist. The caller must close it.
+func (s *S3) GetStream(key Key) (io.ReadCloser, error) {
+	r, err := s.client.GetObject(s.context, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.ns(key)),
+	})
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Body, nil
+}
+
+// SetStream sets the value for the given key from a reader. The S3 client needs to know the length of the body to
+// sign the request, so pass an io.ReadSeeker such as an *os.File where possible.
+func (s *S3) SetStream(key Key, r io.Reader) error {
 	_, err := s.client.PutObject(s.context, &s3.PutObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(s.ns(key)),
-		Body:   bytes.NewReader(value),
+		Body:   r,
 	})
 	return err
 }
 
-// Del deletes the key-value pair for the given key.
-func (s *S3) Del(key Key) error {
-	_, err := s.client.DeleteObject(s.context, &s3.DeleteObjectInput{
+// GetMany returns the values for the given keys. S3 has no batch read, so they are fetched one at a time.
+func (s *S3) GetMany(keys []Key) (map[Key][]byte, error) {
+	values := map[Key][]byte{}
+	for _, key := range keys {
+		value, err := s.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+// DelMany deletes the given keys using as few DeleteObjects requests as possible.
+func (s *S3) DelMany(keys []Key) error {
+	for start := 0; start < len(keys); start += maxDeleteBatch {
+		end := start + maxDeleteBatch
+		if end > len(keys) {
+			end = len(keys)
+		}
+		objects := make([]types.ObjectIdentifier, 0, end-start)
+		for _, key := range keys[start:end] {
+			objects = append(objects, types.ObjectIdentifier{Key: aws.String(s.ns(key))})
+		}
+		out, err := s.client.DeleteObjects(s.context, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &types.Delete{Objects: objects, Quiet: true},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Errors) > 0 {
+			e := out.Errors[0]
+			return fmt.Errorf("failed to delete %d keys, first %s: %s", len(out.Errors), aws.ToString(e.Key), aws.ToString(e.Message))
+		}
+	}
+	return nil
+}
+
+// Stat returns information about the value for the given key using a HEAD request.
+func (s *S3) Stat(key Key) (Info, error) {
+	out, err := s.client.HeadObject(s.context, &s3.HeadObjectInput{
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(s.ns(key)),
 	})
+	if isNotFound(err) {
+		return Info{}, nil
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{
+		Exists:       true,
+		Size:         out.ContentLength,
+		ETag:         aws.ToString(out.ETag),
+		LastModified: aws.ToTime(out.LastModified),
+		Version:      aws.ToString(out.VersionId),
+		Meta: Meta{
+			ContentType:  aws.ToString(out.ContentType),
+			CacheControl: aws.ToString(out.CacheControl),
+			User:         out.Metadata,
+		},
+	}, nil
+}
+
+// Tag replaces the object tags on the value for the given key.
+func (s *S3) Tag(key Key, tags map[string]string) error {
+	tagSet := make([]types.Tag, 0, len(tags))
+	for k, v := range tags {
+		tagSet = append(tagSet, types.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	_, err := s.client.PutObjectTagging(s.context, &s3.PutObjectTaggingInput{
+		Bucket:  aws.String(s.bucket),
+		Key:     aws.String(s.ns(key)),
+		Tagging: &types.Tagging{TagSet: tagSet},
+	})
 	return err
 }
+
+// Versions lists the versions of the given key, newest first, including delete markers. The bucket must have
+// versioning enabled to keep more than the current version; use Supports(Versioning) to check.
+func (s *S3) Versions(key Key) ([]Version, error) {
+	name := s.ns(key)
+	input := &s3.ListObjectVersionsInput{Bucket: aws.String(s.bucket), Prefix: aws.String(name)}
+	var versions []Version
+	for {
+		out, err := s.client.ListObjectVersions(s.context, input)
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range out.Versions {
+			if aws.ToString(v.Key) == name {
+				versions = append(versions, Version{
+					ID:           aws.ToString(v.VersionId),
+					Size:         v.Size,
+					ETag:         aws.ToString(v.ETag),
+					LastModified: aws.ToTime(v.LastModified),
+					Latest:       v.IsLatest,
+				})
+			}
+		}
+		for _, m := range out.DeleteMarkers {
+			if aws.ToString(m.Key) == name {
+				versions = append(versions, Version{
+					ID:           aws.ToString(m.VersionId),
+					LastModified: aws.ToTime(m.LastModified),
+					Latest:       m.IsLatest,
+					Deleted:      true,
+				})
+			}
+		}
+		if !out.IsTruncated {
+			break
+		}
+		input.KeyMarker = out.NextKeyMarker
+		input.VersionIdMarker = out.NextVersionIdMarker
+	}
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].LastModified.After(versions[j].LastModified)
+	})
+	return versions, nil
+}
+
+// GetVersion returns the value of the given version of a key, or nil if the version does not exist or is a delete
+// marker.
+func (s *S3) GetVersion(key Key, id string) ([]byte, error) {
+	r, err := s.client.GetObject(s.context, &s3.GetObjectInput{
+		Bucket:    aws.String(s.bucket),
+		Key:       aws.String(s.ns(key)),
+		VersionId: aws.String(id),
+	})
+	if isNotFound(err) || isDeleteMarker(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Body.Close()
+	return ioutil.ReadAll(r.Body)
+}
+
+// isDeleteMarker returns true if the error from S3 means the requested version is a delete marker, which S3 reports
+// as 405 Method Not Allowed.
+func isDeleteMarker(err error) bool {
+	var coded interface{ ErrorCode() string }
+	return errors.As(err, &coded) && coded.ErrorCode() == "MethodNotAllowed"
+}
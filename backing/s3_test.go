@@ -0,0 +1,183 @@
+package backing_test
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// fakeS3 serves just enough of the S3 API, with path-style addressing, for the S3 backing's core operations.
+type fakeS3 struct {
+	access    sync.Mutex
+	objects   map[string][]byte // by bucket/key
+	versioned bool
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.access.Lock()
+	defer f.access.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket := strings.SplitN(path, "/", 2)[0]
+
+	_, versioning := r.URL.Query()["versioning"]
+	switch {
+	case r.Method == http.MethodGet && versioning:
+		type result struct {
+			XMLName xml.Name `xml:"VersioningConfiguration"`
+			Status  string   `xml:",omitempty"`
+		}
+		res := result{}
+		if f.versioned {
+			res.Status = "Enabled"
+		}
+		_ = xml.NewEncoder(w).Encode(res)
+	case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+		type object struct{ Key string }
+		type result struct {
+			XMLName     xml.Name `xml:"ListBucketResult"`
+			Name        string
+			IsTruncated bool
+			Contents    []object
+		}
+		res := result{Name: bucket}
+		prefix := bucket + "/" + r.URL.Query().Get("prefix")
+		for name := range f.objects {
+			if strings.HasPrefix(name, prefix) {
+				res.Contents = append(res.Contents, object{Key: strings.TrimPrefix(name, bucket+"/")})
+			}
+		}
+		sort.Slice(res.Contents, func(i, j int) bool { return res.Contents[i].Key < res.Contents[j].Key })
+		_ = xml.NewEncoder(w).Encode(res)
+	case r.Method == http.MethodPut:
+		body, _ := ioutil.ReadAll(r.Body)
+		f.objects[path] = body
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		body, ok := f.objects[path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			if r.Method == http.MethodGet {
+				fmt.Fprint(w, "<Error><Code>NoSuchKey</Code></Error>")
+			}
+			return
+		}
+		sum := md5.Sum(body)
+		w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:])+`"`)
+		w.Header().Set("Content-Length", fmt.Sprint(len(body)))
+		if f.versioned {
+			w.Header().Set("x-amz-version-id", hex.EncodeToString(sum[:4]))
+		}
+		if r.Method == http.MethodGet {
+			_, _ = w.Write(body)
+		}
+	}
+}
+
+// newS3 returns an S3 backing for the given namespace of a bucket served by a fake.
+func newS3(server *httptest.Server, namespace string) backing.Backing {
+	// EndpointResolverFromURL shares one endpoint between concurrent requests, which the race detector flags
+	endpoint := s3.EndpointResolverFunc(func(region string, _ s3.EndpointResolverOptions) (aws.Endpoint, error) {
+		return aws.Endpoint{URL: server.URL, SigningRegion: region, Source: aws.EndpointSourceCustom}, nil
+	})
+	client := s3.New(s3.Options{
+		Region:           "us-east-1",
+		Credentials:      aws.AnonymousCredentials{},
+		EndpointResolver: endpoint,
+		UsePathStyle:     true,
+	})
+	b, err := backing.NewS3(backing.S3Args{Bucket: "bucket", Namespace: namespace, Client: client})
+	Expect(err).ToNot(HaveOccurred())
+	return b
+}
+
+var _ = Describe("S3", func() {
+	It("lists keys within its namespace", func() {
+		fake := &fakeS3{objects: map[string][]byte{}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		a, b := newS3(server, "a"), newS3(server, "ab")
+
+		Expect(a.Set("x/1", []byte("1"))).To(Succeed())
+		Expect(a.Set("y", []byte("2"))).To(Succeed())
+		Expect(b.Set("x/2", []byte("3"))).To(Succeed())
+		Expect(a.List("")).To(Equal([]backing.Key{"x/1", "y"}))
+		Expect(a.List("x/")).To(Equal([]backing.Key{"x/1"}))
+		Expect(b.List("")).To(Equal([]backing.Key{"x/2"}))
+		Expect(a.Get("x/1")).To(Equal([]byte("1")))
+		Expect(a.Get("x/2")).To(BeNil())
+	})
+
+	It("reads missing keys as nil through both read paths", func() {
+		fake := &fakeS3{objects: map[string][]byte{}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		b := newS3(server, "a")
+
+		Expect(b.Get("missing")).To(BeNil())
+		Expect(backing.GetStream(b, "missing")).To(BeNil())
+		Expect(backing.GetStream(backingtest.NewMemory(), "missing")).To(BeNil())
+
+		Expect(b.Set("k", []byte("v"))).To(Succeed())
+		r, err := backing.GetStream(b, "k")
+		Expect(err).ToNot(HaveOccurred())
+		defer r.Close()
+		Expect(ioutil.ReadAll(r)).To(Equal([]byte("v")))
+	})
+
+	It("supports versioning only if the bucket has it enabled", func() {
+		fake := &fakeS3{objects: map[string][]byte{}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		b := newS3(server, "a")
+		Expect(backing.Supports(b, backing.Versioning)).To(BeFalse())
+		Expect(backing.Supports(b, backing.Tagging)).To(BeTrue())
+		Expect(backing.Supports(b, backing.ConditionalWrites)).To(BeFalse())
+
+		fake.access.Lock()
+		fake.versioned = true
+		fake.access.Unlock()
+		b = newS3(server, "a")
+		Expect(backing.Supports(b, backing.Versioning)).To(BeTrue())
+		Expect(b.Set("k", []byte("v"))).To(Succeed())
+		info, err := backing.Stat(b, "k")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(info.Version).ToNot(BeEmpty())
+	})
+
+	It("migrates between namespaces", func() {
+		fake := &fakeS3{objects: map[string][]byte{}}
+		server := httptest.NewServer(fake)
+		defer server.Close()
+		src, dst := newS3(server, "old"), newS3(server, "new")
+
+		Expect(src.Set("k1", []byte("v1"))).To(Succeed())
+		Expect(src.Set("dir/k2", []byte("v2"))).To(Succeed())
+		progress, err := backing.Migrate(src, dst, backing.MigrateArgs{Verify: true, CompareETags: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Copied).To(Equal(2))
+		Expect(dst.List("")).To(ConsistOf("k1", "dir/k2"))
+		Expect(fake.objects).To(HaveKeyWithValue("bucket/new/dir/k2", []byte("v2")))
+
+		progress, err = backing.Migrate(src, dst, backing.MigrateArgs{CompareETags: true})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(progress.Skipped).To(Equal(2))
+	})
+})
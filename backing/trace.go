@@ -0,0 +1,155 @@
+package backing
+
+import (
+	"context"
+	"io"
+
+	"github.com/mplewis/s3kv/trace"
+)
+
+// traced is a Backing which creates a span for every operation on another Backing.
+type traced struct {
+	next   Backing
+	tracer trace.Tracer
+}
+
+// Trace wraps a backing so that every operation runs in a root span named "s3kv.backing.<Op>", with the key or
+// prefix as an attribute and any error recorded. Optional operations are passed through to the backing.
+//
+// Trace is for code which uses a backing directly. A Store already traces each backing call under the span of the
+// Store operation that made it, so don't wrap a Store's backing with Trace; its spans would duplicate the Store's,
+// without a parent.
+func Trace(b Backing, t trace.Tracer) Backing {
+	return &traced{next: b, tracer: t}
+}
+
+// TraceMiddleware returns middleware which wraps a backing with Trace.
+func TraceMiddleware(t trace.Tracer) Middleware {
+	return func(next Backing) Backing {
+		return Trace(next, t)
+	}
+}
+
+// start starts the span for a single operation.
+func (b *traced) start(op string, attrs ...trace.Attribute) trace.Span {
+	_, span := b.tracer.Start(context.Background(), "s3kv.backing."+op, attrs...)
+	return span
+}
+
+// Supports returns true if the next backing natively supports the given optional operation.
+func (b *traced) Supports(c Capability) bool {
+	return Supports(b.next, c)
+}
+
+// List lists all keys in the store with the given prefix.
+func (b *traced) List(prefix string) ([]Key, error) {
+	span := b.start("List", trace.String(trace.AttrPrefix, prefix))
+	keys, err := b.next.List(prefix)
+	trace.Finish(span, err)
+	return keys, err
+}
+
+// Get returns the value for the given key.
+func (b *traced) Get(key Key) ([]byte, error) {
+	span := b.start("Get", trace.String(trace.AttrKey, key))
+	value, err := b.next.Get(key)
+	span.SetAttributes(trace.Int(trace.AttrBytes, len(value)))
+	trace.Finish(span, err)
+	return value, err
+}
+
+// Set sets the value for the given key.
+func (b *traced) Set(key Key, value []byte) error {
+	span := b.start("Set", trace.String(trace.AttrKey, key), trace.Int(trace.AttrBytes, len(value)))
+	err := b.next.Set(key, value)
+	trace.Finish(span, err)
+	return err
+}
+
+// Del deletes the key-value pair for the given key.
+func (b *traced) Del(key Key) error {
+	span := b.start("Del", trace.String(trace.AttrKey, key))
+	err := b.next.Del(key)
+	trace.Finish(span, err)
+	return err
+}
+
+// GetStream returns a reader for the value of the given key. The span covers opening the stream.
+func (b *traced) GetStream(key Key) (io.ReadCloser, error) {
+	span := b.start("GetStream", trace.String(trace.AttrKey, key))
+	r, err := GetStream(b.next, key)
+	trace.Finish(span, err)
+	return r, err
+}
+
+// SetStream sets the value for the given key from a reader.
+func (b *traced) SetStream(key Key, r io.Reader) error {
+	span := b.start("SetStream", trace.String(trace.AttrKey, key))
+	err := SetStream(b.next, key, r)
+	trace.Finish(span, err)
+	return err
+}
+
+// SetIfAbsent sets the value for the given key only if it does not already exist.
+func (b *traced) SetIfAbsent(key Key, value []byte) (bool, error) {
+	span := b.start("SetIfAbsent", trace.String(trace.AttrKey, key), trace.Int(trace.AttrBytes, len(value)))
+	ok, err := SetIfAbsent(b.next, key, value)
+	trace.Finish(span, err)
+	return ok, err
+}
+
+// GetMany returns the values for the given keys.
+func (b *traced) GetMany(keys []Key) (map[Key][]byte, error) {
+	span := b.start("GetMany", trace.Strings(trace.AttrKeys, keys))
+	values, err := GetMany(b.next, keys)
+	trace.Finish(span, err)
+	return values, err
+}
+
+// DelMany deletes the given keys.
+func (b *traced) DelMany(keys []Key) error {
+	span := b.start("DelMany", trace.Strings(trace.AttrKeys, keys))
+	err := DelMany(b.next, keys)
+	trace.Finish(span, err)
+	return err
+}
+
+// Stat returns information about the value for the given key.
+func (b *traced) Stat(key Key) (Info, error) {
+	span := b.start("Stat", trace.String(trace.AttrKey, key))
+	info, err := Stat(b.next, key)
+	trace.Finish(span, err)
+	return info, err
+}
+
+// Tag replaces the tags on the value for the given key.
+func (b *traced) Tag(key Key, tags map[string]string) error {
+	span := b.start("Tag", trace.String(trace.AttrKey, key))
+	err := Tag(b.next, key, tags)
+	trace.Finish(span, err)
+	return err
+}
+
+// SetWithMeta sets the value and metadata for the given key.
+func (b *traced) SetWithMeta(key Key, value []byte, meta Meta) error {
+	span := b.start("SetWithMeta", trace.String(trace.AttrKey, key), trace.Int(trace.AttrBytes, len(value)))
+	err := SetWithMeta(b.next, key, value, meta)
+	trace.Finish(span, err)
+	return err
+}
+
+// Versions lists the versions of the given key.
+func (b *traced) Versions(key Key) ([]Version, error) {
+	span := b.start("Versions", trace.String(trace.AttrKey, key))
+	versions, err := Versions(b.next, key)
+	trace.Finish(span, err)
+	return versions, err
+}
+
+// GetVersion returns the value of the given version of a key.
+func (b *traced) GetVersion(key Key, id string) ([]byte, error) {
+	span := b.start("GetVersion", trace.String(trace.AttrKey, key))
+	value, err := GetVersion(b.next, key, id)
+	trace.Finish(span, err)
+	return value, err
+}
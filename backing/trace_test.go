@@ -0,0 +1,34 @@
+package backing_test
+
+import (
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	"github.com/mplewis/s3kv/trace"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Trace", func() {
+	It("records a span per operation", func() {
+		rec := trace.NewRecorder()
+		b := backing.Trace(backingtest.NewMemory(), rec)
+
+		Expect(b.Set("k", []byte("abc"))).To(Succeed())
+		_, err := b.Get("k")
+		Expect(err).ToNot(HaveOccurred())
+
+		err = backing.Trace(failing{}, rec).Del("k")
+		Expect(err).To(MatchError(errBoom))
+
+		spans := rec.Spans()
+		Expect(spans).To(HaveLen(3))
+		Expect(spans[0].Name).To(Equal("s3kv.backing.Set"))
+		Expect(spans[0].ParentID).To(BeZero())
+		Expect(spans[0].Attributes).To(HaveKeyWithValue(trace.AttrKey, "k"))
+		Expect(spans[0].Attributes).To(HaveKeyWithValue(trace.AttrBytes, 3))
+		Expect(spans[1].Name).To(Equal("s3kv.backing.Get"))
+		Expect(spans[1].End).ToNot(BeZero())
+		Expect(spans[2].Name).To(Equal("s3kv.backing.Del"))
+		Expect(spans[2].Err).To(MatchError(errBoom))
+	})
+})
@@ -0,0 +1,40 @@
+package backing
+
+import "time"
+
+// Version describes one version of a value in a backing which keeps a history, such as a versioned S3 bucket.
+type Version struct {
+	ID           string    // Identifies the version to GetVersion.
+	Size         int64     // The size of the value as stored. Zero for deletions.
+	ETag         string    // An opaque identifier for the contents of the version.
+	LastModified time.Time // When the version was written.
+	Latest       bool      // True for the current version of the key.
+	Deleted      bool      // True if this version records the key being deleted.
+}
+
+// Versioner is implemented by backings which keep prior versions of each value, such as S3 buckets with versioning
+// enabled. Middleware in a Chain does not expose it, since versions are read without the middleware's transforms.
+type Versioner interface {
+	// Versions lists the versions of the given key, newest first.
+	Versions(key Key) ([]Version, error)
+	// GetVersion returns the value of the given version of a key, or nil if the version does not exist or is a
+	// deletion.
+	GetVersion(key Key, id string) ([]byte, error)
+}
+
+// Versions lists the versions of the given key, newest first, or returns ErrUnsupported if the backing is not a
+// Versioner.
+func Versions(b Backing, key Key) ([]Version, error) {
+	if v, ok := b.(Versioner); ok {
+		return v.Versions(key)
+	}
+	return nil, ErrUnsupported
+}
+
+// GetVersion returns the value of the given version of a key, or ErrUnsupported if the backing is not a Versioner.
+func GetVersion(b Backing, key Key, id string) ([]byte, error) {
+	if v, ok := b.(Versioner); ok {
+		return v.GetVersion(key, id)
+	}
+	return nil, ErrUnsupported
+}
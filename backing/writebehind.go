@@ -0,0 +1,261 @@
+package backing
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WriteBehindArgs are the arguments for a write-behind backing.
+type WriteBehindArgs struct {
+	Interval     time.Duration            // Optional. How often pending writes are flushed. Defaults to 1 s.
+	MaxPending   int                      // Optional. Flush early once this many keys have pending writes. Defaults to 1000.
+	MaxBytes     int64                    // Optional. Flush early once pending values total this many bytes. Defaults to 8 MiB.
+	OnFlushError func(key Key, err error) // Optional. Called for each pending write which fails to flush. The write is dropped.
+}
+
+// Default values for WriteBehindArgs, if unset.
+const (
+	defaultWriteBehindInterval   = time.Second
+	defaultWriteBehindMaxPending = 1000
+	defaultWriteBehindMaxBytes   = 8 << 20
+)
+
+// pendingWrite is a buffered Set, or a buffered Del if deleted is true.
+type pendingWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// WriteBehind is a Backing which buffers writes in memory and flushes them to another Backing in the background.
+// Repeated writes to the same key between flushes are coalesced into one, trading a short durability window for
+// fewer requests. Reads see pending writes immediately.
+type WriteBehind struct {
+	next Backing
+	args WriteBehindArgs
+
+	access   sync.Mutex
+	pending  map[Key]pendingWrite
+	inflight map[Key]pendingWrite // writes being flushed right now, still visible to reads
+	bytes    int64
+	closed   bool
+
+	flushed chan struct{} // closed once Close has flushed, so writes made after it can go straight through
+
+	flushing sync.Mutex // serializes flushes
+	trigger  chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewWriteBehind wraps a backing with a write-behind buffer and starts flushing it in the background. Call Close
+// to flush the remaining writes and stop.
+func NewWriteBehind(b Backing, args WriteBehindArgs) *WriteBehind {
+	if args.Interval == 0 {
+		args.Interval = defaultWriteBehindInterval
+	}
+	if args.MaxPending == 0 {
+		args.MaxPending = defaultWriteBehindMaxPending
+	}
+	if args.MaxBytes == 0 {
+		args.MaxBytes = defaultWriteBehindMaxBytes
+	}
+	w := &WriteBehind{
+		next:     b,
+		args:     args,
+		pending:  map[Key]pendingWrite{},
+		inflight: map[Key]pendingWrite{},
+		trigger:  make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		flushed:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// run flushes on every interval, or early when triggered, until closed.
+func (w *WriteBehind) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.args.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ticker.C:
+		case <-w.trigger:
+		}
+		_ = w.Flush()
+	}
+}
+
+// buffer records a copy of a pending write. If the buffer is closed, it waits for the final flush and returns false
+// so that the write goes straight through without being overwritten by an older buffered write.
+func (w *WriteBehind) buffer(key Key, p pendingWrite) bool {
+	w.access.Lock()
+	if w.closed {
+		w.access.Unlock()
+		<-w.flushed
+		return false
+	}
+	defer w.access.Unlock()
+	if !p.deleted {
+		p.value = append(make([]byte, 0, len(p.value)), p.value...)
+	}
+
+	if old, ok := w.pending[key]; ok {
+		w.bytes -= int64(len(old.value))
+	}
+	w.pending[key] = p
+	w.bytes += int64(len(p.value))
+
+	if len(w.pending) >= w.args.MaxPending || w.bytes >= w.args.MaxBytes {
+		select {
+		case w.trigger <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// Flush writes every pending write to the next backing. Failed writes are reported to OnFlushError and dropped;
+// Flush returns the first failure.
+func (w *WriteBehind) Flush() error {
+	w.flushing.Lock()
+	defer w.flushing.Unlock()
+
+	w.access.Lock()
+	batch := w.pending
+	w.inflight = batch
+	w.pending = map[Key]pendingWrite{}
+	w.bytes = 0
+	w.access.Unlock()
+
+	keys := make([]Key, 0, len(batch))
+	for key := range batch {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var first error
+	for _, key := range keys {
+		p := batch[key]
+		var err error
+		if p.deleted {
+			err = w.next.Del(key)
+		} else {
+			err = w.next.Set(key, p.value)
+		}
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			if w.args.OnFlushError != nil {
+				w.args.OnFlushError(key, err)
+			}
+		}
+	}
+
+	w.access.Lock()
+	w.inflight = map[Key]pendingWrite{}
+	w.access.Unlock()
+	return first
+}
+
+// Close stops the background flusher and flushes any remaining writes. Writes made after Close go straight to the
+// next backing, once the final flush has finished. Calling Close more than once is safe.
+func (w *WriteBehind) Close() error {
+	w.access.Lock()
+	if w.closed {
+		w.access.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.access.Unlock()
+
+	close(w.done)
+	<-w.stopped
+	defer close(w.flushed)
+	return w.Flush()
+}
+
+// Pending returns the number of keys with writes waiting to be flushed.
+func (w *WriteBehind) Pending() int {
+	w.access.Lock()
+	defer w.access.Unlock()
+	return len(w.pending)
+}
+
+// lookup returns the pending or in-flight write for a key, if there is one.
+func (w *WriteBehind) lookup(key Key) (pendingWrite, bool) {
+	w.access.Lock()
+	defer w.access.Unlock()
+	if p, ok := w.pending[key]; ok {
+		return p, true
+	}
+	p, ok := w.inflight[key]
+	return p, ok
+}
+
+// List lists all keys with the given prefix, including keys with pending writes.
+func (w *WriteBehind) List(prefix string) ([]Key, error) {
+	keys, err := w.next.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	w.access.Lock()
+	defer w.access.Unlock()
+	overlay := map[Key]pendingWrite{}
+	for key, p := range w.inflight {
+		overlay[key] = p
+	}
+	for key, p := range w.pending {
+		overlay[key] = p
+	}
+
+	merged := make([]Key, 0, len(keys))
+	seen := map[Key]bool{}
+	for _, key := range keys {
+		if p, ok := overlay[key]; ok && p.deleted {
+			continue
+		}
+		seen[key] = true
+		merged = append(merged, key)
+	}
+	for key, p := range overlay {
+		if !p.deleted && !seen[key] && strings.HasPrefix(key, prefix) {
+			merged = append(merged, key)
+		}
+	}
+	return merged, nil
+}
+
+// Get returns the value for the given key, from the pending writes if there is one.
+func (w *WriteBehind) Get(key Key) ([]byte, error) {
+	if p, ok := w.lookup(key); ok {
+		if p.deleted {
+			return nil, nil
+		}
+		return append(make([]byte, 0, len(p.value)), p.value...), nil
+	}
+	return w.next.Get(key)
+}
+
+// Set buffers a write of the value for the given key.
+func (w *WriteBehind) Set(key Key, value []byte) error {
+	if w.buffer(key, pendingWrite{value: value}) {
+		return nil
+	}
+	return w.next.Set(key, value)
+}
+
+// Del buffers a deletion of the given key.
+func (w *WriteBehind) Del(key Key) error {
+	if w.buffer(key, pendingWrite{deleted: true}) {
+		return nil
+	}
+	return w.next.Del(key)
+}
@@ -0,0 +1,131 @@
+package backing_test
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("WriteBehind", func() {
+	It("coalesces writes and serves reads from the buffer", func() {
+		m := backingtest.NewMemory()
+		m.Data["old"] = []byte("x")
+		m.Data["gone"] = []byte("x")
+		w := backing.NewWriteBehind(m, backing.WriteBehindArgs{Interval: time.Hour})
+		defer w.Close()
+
+		for _, v := range []string{"1", "2", "3"} {
+			Expect(w.Set("counter", []byte(v))).To(Succeed())
+		}
+		Expect(w.Del("gone")).To(Succeed())
+		Expect(w.Get("counter")).To(Equal([]byte("3")))
+		Expect(w.Get("gone")).To(BeNil())
+		Expect(w.List("")).To(ConsistOf("old", "counter"))
+		Expect(w.Pending()).To(Equal(2))
+		Expect(m.Calls()).To(Equal([]string{"list "}))
+
+		Expect(w.Flush()).To(Succeed())
+		Expect(m.Calls()).To(Equal([]string{"set counter", "del gone"}))
+		Expect(m.Data).To(Equal(map[string][]byte{"old": []byte("x"), "counter": []byte("3")}))
+		Expect(w.Pending()).To(BeZero())
+	})
+
+	It("flushes on an interval", func() {
+		m := backingtest.NewMemory()
+		w := backing.NewWriteBehind(m, backing.WriteBehindArgs{Interval: 10 * time.Millisecond})
+		defer w.Close()
+
+		Expect(w.Set("k", []byte("v"))).To(Succeed())
+		Eventually(w.Pending).Should(BeZero())
+		m.Lock()
+		defer m.Unlock()
+		Expect(m.Data["k"]).To(Equal([]byte("v")))
+	})
+
+	It("flushes early when the buffer is full", func() {
+		m := backingtest.NewMemory()
+		w := backing.NewWriteBehind(m, backing.WriteBehindArgs{Interval: time.Hour, MaxPending: 2})
+		defer w.Close()
+
+		Expect(w.Set("a", nil)).To(Succeed())
+		Expect(w.Set("b", nil)).To(Succeed())
+		Eventually(w.Pending).Should(BeZero())
+	})
+
+	It("flushes remaining writes on close and writes through afterwards", func() {
+		m := backingtest.NewMemory()
+		w := backing.NewWriteBehind(m, backing.WriteBehindArgs{Interval: time.Hour})
+		Expect(w.Set("k", []byte("v"))).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+		Expect(w.Close()).To(Succeed())
+		Expect(m.Data["k"]).To(Equal([]byte("v")))
+
+		Expect(w.Set("after", []byte("v"))).To(Succeed())
+		Expect(m.Data["after"]).To(Equal([]byte("v")))
+	})
+
+	It("keeps its own copy of buffered values", func() {
+		m := backingtest.NewMemory()
+		w := backing.NewWriteBehind(m, backing.WriteBehindArgs{Interval: time.Hour})
+		value := []byte("v")
+		Expect(w.Set("k", value)).To(Succeed())
+		value[0] = 'x'
+		got, err := w.Get("k")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(got).To(Equal([]byte("v")))
+		got[0] = 'y'
+		Expect(w.Get("k")).To(Equal([]byte("v")))
+		Expect(w.Close()).To(Succeed())
+		Expect(m.Data["k"]).To(Equal([]byte("v")))
+	})
+
+	It("holds writes made during close until the final flush is done", func() {
+		m := backingtest.NewMemory()
+		release := make(chan struct{})
+		gate := backing.Intercept(backing.Interceptor{
+			Set: func(key backing.Key, value []byte, next func(backing.Key, []byte) error) error {
+				if string(value) == "old" {
+					<-release
+				}
+				return next(key, value)
+			},
+		})
+		w := backing.NewWriteBehind(gate(m), backing.WriteBehindArgs{Interval: time.Hour})
+		Expect(w.Set("k", []byte("old"))).To(Succeed())
+
+		closed := make(chan error)
+		go func() { closed <- w.Close() }()
+		Eventually(w.Pending).Should(BeZero()) // the final flush has taken the buffered write
+		written := make(chan error)
+		go func() { written <- w.Set("k", []byte("new")) }()
+		Consistently(written, 20*time.Millisecond).ShouldNot(Receive())
+
+		close(release)
+		Eventually(closed).Should(Receive(BeNil()))
+		Eventually(written).Should(Receive(BeNil()))
+		m.Lock()
+		defer m.Unlock()
+		Expect(m.Data["k"]).To(Equal([]byte("new")))
+	})
+
+	It("reports flush failures", func() {
+		var mu sync.Mutex
+		var failed []string
+		w := backing.NewWriteBehind(failing{}, backing.WriteBehindArgs{
+			Interval: time.Hour,
+			OnFlushError: func(key backing.Key, err error) {
+				mu.Lock()
+				defer mu.Unlock()
+				failed = append(failed, key+": "+err.Error())
+			},
+		})
+		Expect(w.Set("a", nil)).To(Succeed())
+		Expect(w.Del("b")).To(Succeed())
+		Expect(w.Close()).To(MatchError(errBoom))
+		Expect(failed).To(Equal([]string{"a: boom", "b: boom"}))
+	})
+})
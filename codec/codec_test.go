@@ -0,0 +1,64 @@
+package codec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mplewis/s3kv/codec"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestCodec(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Codec Suite")
+}
+
+type user struct {
+	Name string
+	Age  int
+}
+
+var _ = Describe("codecs", func() {
+	It("round-trips values with a content type marker", func() {
+		for _, c := range []codec.Codec{codec.JSON, codec.Gob} {
+			data, err := codec.Encode(c, user{"ada", 36})
+			Expect(err).NotTo(HaveOccurred())
+			ct, _, ok := codec.Split(data)
+			Expect(ok).To(BeTrue())
+			Expect(ct).To(Equal(c.ContentType()))
+
+			u := user{}
+			Expect(codec.Decode(c, data, &u)).To(Succeed())
+			Expect(u).To(Equal(user{"ada", 36}))
+		}
+	})
+
+	It("round-trips protocol buffers", func() {
+		data, err := codec.Encode(codec.Proto, wrapperspb.String("hi"))
+		Expect(err).NotTo(HaveOccurred())
+		m := &wrapperspb.StringValue{}
+		Expect(codec.Decode(codec.Proto, data, m)).To(Succeed())
+		Expect(proto.Equal(m, wrapperspb.String("hi"))).To(BeTrue())
+
+		_, err = codec.Encode(codec.Proto, user{})
+		Expect(err).To(MatchError(ContainSubstring("is not a proto.Message")))
+	})
+
+	It("rejects values written with another codec", func() {
+		data, err := codec.Encode(codec.Gob, user{"ada", 36})
+		Expect(err).NotTo(HaveOccurred())
+		err = codec.Decode(codec.JSON, data, &user{})
+		Expect(errors.Is(err, codec.ErrMismatch)).To(BeTrue())
+		Expect(err).To(MatchError(ContainSubstring("value is application/x-gob, not application/json")))
+	})
+
+	It("decodes unmarked values directly", func() {
+		u := user{}
+		Expect(codec.Decode(codec.JSON, []byte(`{"Name":"bob"}`), &u)).To(Succeed())
+		Expect(u.Name).To(Equal("bob"))
+		Expect(codec.Decode(codec.JSON, []byte(`nope`), &u)).To(MatchError(ContainSubstring("decoding application/json")))
+	})
+})
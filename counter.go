@@ -0,0 +1,184 @@
+package s3kv
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mplewis/s3kv/trace"
+)
+
+// ErrNotInteger is returned when incrementing a key whose value is not an integer.
+var ErrNotInteger = errors.New("value is not an integer")
+
+// EncodeInt returns the stored form of an integer counter. Counters are stored as base-10 ASCII, such as "42", so
+// they can also be read with Get and strconv.ParseInt.
+func EncodeInt(n int64) []byte {
+	return []byte(strconv.FormatInt(n, 10))
+}
+
+// DecodeInt parses the stored form of an integer counter. A nil value, from a missing key, is zero.
+func DecodeInt(value []byte) (int64, error) {
+	if value == nil {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(string(value), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrNotInteger, value)
+	}
+	return n, nil
+}
+
+// Incr adds delta to the integer counter for the given key and returns the new value. It locks the key for the
+// duration of the update, so concurrent increments through this store never lose updates. Since it takes its own
+// lock, don't call it while holding the key in a session: it would wait on that session and time out. A counter set
+// with SetWithTTL keeps its expiry, and an expired counter starts again from zero without one.
+func (s *Store) Incr(key string, delta int64) (int64, error) {
+	sid, err := s.sloto.Lock(key)
+	if err != nil {
+		return 0, err
+	}
+	defer s.sloto.Unlock(sid)
+
+	op := s.begin("Incr", key, sid)
+	var value []byte
+	err = op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		value, err = s.backing.Get(s.ns1(key))
+		return err
+	})
+	_, expires := splitExpiry(value)
+	if !expires.IsZero() && !time.Now().Before(expires) {
+		expires = time.Time{}
+	}
+	var n int64
+	if err == nil {
+		value, err = s.decode(op, key, value)
+	}
+	if err == nil {
+		n, err = DecodeInt(value)
+		if err != nil {
+			err = fmt.Errorf("incrementing %s: %w", key, err)
+		}
+	}
+	if err == nil {
+		n += delta
+		stored := EncodeInt(n)
+		if !expires.IsZero() {
+			stored = withExpiry(stored, expires)
+		}
+		err = s.write(op, key, stored, EncodeInt(n))
+	}
+	op.end(err)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Decr subtracts delta from the integer counter for the given key and returns the new value.
+func (s *Store) Decr(key string, delta int64) (int64, error) {
+	return s.Incr(key, -delta)
+}
+
+// CounterArgs are the arguments for a batch of counters.
+type CounterArgs struct {
+	FlushInterval time.Duration // Optional. How often to write pending increments in the background. Defaults to never; call Flush instead.
+	OnFlushError  func(error)   // Optional. Called when a background flush fails. The increments stay pending and are retried.
+}
+
+// Counters aggregates increments in memory and writes them to the store in batches, trading durability for far fewer
+// backing requests on hot counters. Pending increments are lost if the process exits without flushing.
+type Counters struct {
+	store   *Store
+	onError func(error)
+
+	access  sync.Mutex
+	pending map[Key]int64
+	flush   sync.Mutex
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// Counters returns a batch of counters which writes to this store.
+func (s *Store) Counters(args CounterArgs) *Counters {
+	c := &Counters{
+		store:   s,
+		onError: args.OnFlushError,
+		pending: map[Key]int64{},
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	if args.FlushInterval > 0 {
+		go c.flushEvery(args.FlushInterval)
+	} else {
+		close(c.stopped)
+	}
+	return c
+}
+
+// Add adds delta to the pending increment for the given key without writing it.
+func (c *Counters) Add(key string, delta int64) {
+	c.access.Lock()
+	defer c.access.Unlock()
+	c.pending[key] += delta
+}
+
+// Pending returns the increment for the given key which has not been written yet.
+func (c *Counters) Pending(key string) int64 {
+	c.access.Lock()
+	defer c.access.Unlock()
+	return c.pending[key]
+}
+
+// Flush writes all pending increments with Incr. Increments which fail to write stay pending, and the first error is
+// returned.
+func (c *Counters) Flush() error {
+	c.flush.Lock()
+	defer c.flush.Unlock()
+
+	c.access.Lock()
+	batch := c.pending
+	c.pending = map[Key]int64{}
+	c.access.Unlock()
+
+	var first error
+	for key, delta := range batch {
+		if delta == 0 {
+			continue
+		}
+		if _, err := c.store.Incr(key, delta); err != nil {
+			c.Add(key, delta)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Close stops flushing in the background and writes any pending increments.
+func (c *Counters) Close() error {
+	c.once.Do(func() { close(c.done) })
+	<-c.stopped
+	return c.Flush()
+}
+
+// flushEvery flushes at the given interval until the counters are closed.
+func (c *Counters) flushEvery(interval time.Duration) {
+	defer close(c.stopped)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.Flush(); err != nil && c.onError != nil {
+				c.onError(err)
+			}
+		}
+	}
+}
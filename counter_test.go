@@ -0,0 +1,113 @@
+package s3kv_test
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/mplewis/s3kv"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("counters", func() {
+	It("increments and decrements without losing updates", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "count", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		Expect(s.Incr("hits", 5)).To(BeEquivalentTo(5))
+		Expect(s.Decr("hits", 2)).To(BeEquivalentTo(3))
+		Expect(s.Get("hits")).To(Equal([]byte("3")))
+
+		wg := sync.WaitGroup{}
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer GinkgoRecover()
+				defer wg.Done()
+				_, err := s.Incr("hits", 1)
+				Expect(err).NotTo(HaveOccurred())
+			}()
+		}
+		wg.Wait()
+		Expect(s.Get("hits")).To(Equal([]byte("53")))
+	})
+
+	It("refuses to increment values which are not integers", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "count", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("name")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Set(sid, "name", []byte("bob"))).To(Succeed())
+		s.Unlock(sid)
+
+		_, err = s.Incr("name", 1)
+		Expect(errors.Is(err, s3kv.ErrNotInteger)).To(BeTrue())
+		Expect(s.Get("name")).To(Equal([]byte("bob")))
+	})
+
+	It("keeps the expiry of counters set with a TTL", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "count", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("window")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.SetWithTTL(sid, "window", s3kv.EncodeInt(5), short)).To(Succeed())
+		s.Unlock(sid)
+
+		Expect(s.Incr("window", 1)).To(BeEquivalentTo(6))
+		Expect(s.Get("window")).To(Equal([]byte("6")))
+		time.Sleep(short * 2)
+		Expect(s.Get("window")).To(BeNil())
+		Expect(s.Incr("window", 1)).To(BeEquivalentTo(1))
+		time.Sleep(short * 2)
+		Expect(s.Get("window")).To(Equal([]byte("1")))
+	})
+
+	It("aggregates increments before writing them", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "count", Backing: b})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		c := s.Counters(s3kv.CounterArgs{})
+		c.Add("a", 2)
+		c.Add("a", 3)
+		c.Add("b", -1)
+		Expect(c.Pending("a")).To(BeEquivalentTo(5))
+		Expect(s.Get("a")).To(BeNil())
+
+		Expect(c.Flush()).To(Succeed())
+		Expect(c.Pending("a")).To(BeZero())
+		Expect(s.Get("a")).To(Equal([]byte("5")))
+		Expect(s.Get("b")).To(Equal([]byte("-1")))
+
+		c.Add("a", 1)
+		Expect(c.Close()).To(Succeed())
+		Expect(s.Get("a")).To(Equal([]byte("6")))
+	})
+
+	It("flushes in the background and keeps failed increments pending", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "count", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("bad")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Set(sid, "bad", []byte("nope"))).To(Succeed())
+		s.Unlock(sid)
+
+		errs := make(chan error, 10)
+		c := s.Counters(s3kv.CounterArgs{FlushInterval: short, OnFlushError: func(err error) { errs <- err }})
+		c.Add("good", 1)
+		c.Add("bad", 1)
+		Eventually(errs, long).Should(Receive(MatchError(ContainSubstring("incrementing bad"))))
+		Expect(s.Get("good")).To(Equal([]byte("1")))
+		Expect(c.Pending("bad")).To(BeEquivalentTo(1))
+		Expect(c.Close()).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,270 @@
+package s3kv
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/trace"
+)
+
+// ErrNoHistory is returned by History, GetVersion and Restore when the store does not keep versions.
+var ErrNoHistory = errors.New("version history is not enabled")
+
+// HistoryArgs configure the version history a store keeps on backings without native versioning.
+type HistoryArgs struct {
+	MaxVersions int           // Optional. The most versions to keep per key, including the current one. Defaults to 10.
+	MaxAge      time.Duration // Optional. Discard versions older than this, except the current one. Defaults to forever.
+}
+
+// Default values for HistoryArgs, if unset.
+const defaultMaxVersions = 10
+
+// versionNamespace is appended to the store's namespace to form the namespace for emulated versions, which keeps
+// them out of List results.
+const versionNamespace = ".versions"
+
+// Kinds of emulated version, stored as the first byte of each version object.
+const (
+	versionValue   byte = 'v'
+	versionDeleted byte = 'd'
+)
+
+// versionPrefix returns the backing key prefix for the emulated versions of the given key.
+func (s *Store) versionPrefix(key string) string {
+	return s.namespace + versionNamespace + NS_DELIM + key + NS_DELIM
+}
+
+// versionSeq numbers the emulated versions written by this process, so writes within the same nanosecond still get
+// distinct IDs.
+var versionSeq uint64
+
+// newVersionID returns an ID for an emulated version written now. IDs sort in the order they were written.
+func newVersionID(now time.Time) string {
+	return fmt.Sprintf("%020d.%020d", now.UnixNano(), atomic.AddUint64(&versionSeq, 1))
+}
+
+// versionTime returns the time an emulated version was written, from its ID. IDs written before sequence numbers
+// were added have no suffix.
+func versionTime(id string) (time.Time, bool) {
+	if len(id) < 20 || (len(id) > 20 && id[20] != '.') {
+		return time.Time{}, false
+	}
+	nanos, err := strconv.ParseInt(id[:20], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	if len(id) > 20 {
+		if _, err := strconv.ParseUint(id[21:], 10, 64); err != nil {
+			return time.Time{}, false
+		}
+	}
+	return time.Unix(0, nanos), true
+}
+
+// History lists the versions of the given key, newest first, including deletions.
+//
+// On backings which support backing.Versioning, such as S3 buckets with versioning enabled, this is the bucket's
+// own history. Otherwise the store keeps an emulated history if Args.History is set, recording a copy of each value
+// it writes, and returns ErrNoHistory if not.
+func (s *Store) History(key string) ([]Version, error) {
+	op := s.begin("History", key, "")
+	versions, err := s.history(op, key)
+	op.end(err)
+	return versions, err
+}
+
+// history lists the versions of the given key.
+func (s *Store) history(op *operation, key string) ([]Version, error) {
+	var versions []Version
+	if s.nativeVersions {
+		err := op.call("Versions", trace.String(trace.AttrKey, key), func() (err error) {
+			versions, err = backing.Versions(s.backing, s.ns1(key))
+			return err
+		})
+		return versions, err
+	}
+	if s.historyArgs == nil {
+		return nil, ErrNoHistory
+	}
+
+	prefix := s.versionPrefix(key)
+	var keys []Key
+	err := op.call("List", trace.String(trace.AttrPrefix, prefix), func() (err error) {
+		keys, err = s.backing.List(prefix)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, k := range keys {
+		id := strings.TrimPrefix(k, prefix)
+		if _, ok := versionTime(id); ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+
+	var stored map[Key][]byte
+	err = op.call("GetMany", trace.String(trace.AttrKey, key), func() (err error) {
+		vkeys := make([]Key, len(ids))
+		for i, id := range ids {
+			vkeys[i] = prefix + id
+		}
+		stored, err = backing.GetMany(s.backing, vkeys)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		data, ok := stored[prefix+id]
+		if !ok || len(data) == 0 {
+			continue
+		}
+		written, _ := versionTime(id)
+		v := Version{ID: id, LastModified: written, Latest: len(versions) == 0, Deleted: data[0] == versionDeleted}
+		if !v.Deleted {
+			v.Size = int64(len(data) - 1)
+			v.ETag = etag(data[1:])
+		}
+		versions = append(versions, v)
+	}
+	return versions, nil
+}
+
+// GetVersion returns the value of the given version of a key, or nil if the version does not exist or records a
+// deletion.
+func (s *Store) GetVersion(key string, id string) ([]byte, error) {
+	op := s.begin("GetVersion", key, "")
+	value, err := s.getVersion(op, key, id)
+	op.end(err)
+	return value, err
+}
+
+// getVersion returns the value of the given version of a key.
+func (s *Store) getVersion(op *operation, key string, id string) ([]byte, error) {
+	if !s.nativeVersions && s.historyArgs == nil {
+		return nil, ErrNoHistory
+	}
+	var data []byte
+	err := op.call("GetVersion", trace.String(trace.AttrKey, key), func() (err error) {
+		if s.nativeVersions {
+			data, err = backing.GetVersion(s.backing, s.ns1(key), id)
+		} else {
+			data, err = s.backing.Get(s.versionPrefix(key) + id)
+		}
+		return err
+	})
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	if !s.nativeVersions {
+		if len(data) == 0 {
+			return nil, fmt.Errorf("version %s of %s is empty", id, key)
+		}
+		if data[0] == versionDeleted {
+			return nil, nil
+		}
+		return data[1:], nil
+	}
+	if _, segmented, _ := parseIndex(key, data); segmented {
+		return nil, fmt.Errorf("version %s of %s was written by a segmented Append and can't be read on its own", id, key)
+	}
+	value, _ := splitExpiry(data)
+	return value, nil
+}
+
+// Restore makes the given version of a key its current value, as a new version. Restoring a deletion deletes the
+// key. You must have an open session for the key.
+func (s *Store) Restore(sid SessionID, key string, id string) error {
+	op := s.begin("Restore", key, sid)
+	err := s.check(sid, key)
+	var value []byte
+	if err == nil {
+		value, err = s.getVersion(op, key, id)
+	}
+	if err == nil && value == nil {
+		err = s.restoreDeletion(op, key, id)
+		if err == nil {
+			err = s.remove(op, key)
+		}
+	} else if err == nil {
+		err = s.write(op, key, encodeValue(value), value)
+	}
+	op.end(err)
+	return err
+}
+
+// restoreDeletion returns nil if the given version records a deletion, and an error if it does not exist.
+func (s *Store) restoreDeletion(op *operation, key string, id string) error {
+	versions, err := s.history(op, key)
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if v.ID == id && v.Deleted {
+			return nil
+		}
+	}
+	return fmt.Errorf("version %s of %s does not exist", id, key)
+}
+
+// record adds a version to the emulated history of a key after a write, then prunes old versions. A nil value
+// records a deletion. It does nothing unless the store keeps an emulated history.
+func (s *Store) record(op *operation, key string, value []byte) error {
+	if s.nativeVersions || s.historyArgs == nil {
+		return nil
+	}
+	now := time.Now()
+	data := []byte{versionDeleted}
+	if value != nil {
+		data = append([]byte{versionValue}, value...)
+	}
+	prefix := s.versionPrefix(key)
+	err := op.call("Set", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Set(prefix+newVersionID(now), data)
+	})
+	if err != nil {
+		return err
+	}
+
+	var keys []Key
+	err = op.call("List", trace.String(trace.AttrPrefix, prefix), func() (err error) {
+		keys, err = s.backing.List(prefix)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var ids []string
+	for _, k := range keys {
+		if id := strings.TrimPrefix(k, prefix); !strings.Contains(id, NS_DELIM) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(ids)))
+	var expired []Key
+	for i, id := range ids {
+		written, ok := versionTime(id)
+		if !ok || i == 0 {
+			continue
+		}
+		if i >= s.historyArgs.MaxVersions || (s.historyArgs.MaxAge > 0 && now.Sub(written) > s.historyArgs.MaxAge) {
+			expired = append(expired, prefix+id)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+	return op.call("DelMany", trace.String(trace.AttrKey, key), func() error {
+		return backing.DelMany(s.backing, expired)
+	})
+}
@@ -0,0 +1,201 @@
+package s3kv_test
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mplewis/s3kv"
+	"github.com/mplewis/s3kv/backing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// versionedBacking keeps every value written to it, like a versioned S3 bucket.
+type versionedBacking struct {
+	*taggingBacking
+	versions map[string][]backing.Version
+	values   map[string][]byte
+	listed   int // How many times Versions was called.
+}
+
+func newVersionedBacking() *versionedBacking {
+	return &versionedBacking{taggingBacking: newTaggingBacking(), versions: map[string][]backing.Version{}, values: map[string][]byte{}}
+}
+
+func (b *versionedBacking) push(key s3kv.Key, value []byte) {
+	id := key + "@" + time.Now().Format(time.RFC3339Nano)
+	for i := range b.versions[key] {
+		b.versions[key][i].Latest = false
+	}
+	v := backing.Version{ID: id, Size: int64(len(value)), Latest: true, Deleted: value == nil}
+	b.versions[key] = append([]backing.Version{v}, b.versions[key]...)
+	b.values[id] = value
+}
+
+func (b *versionedBacking) Set(key s3kv.Key, value []byte) error {
+	b.push(key, value)
+	return b.Memory.Set(key, value)
+}
+
+func (b *versionedBacking) Del(key s3kv.Key) error {
+	b.push(key, nil)
+	return b.Memory.Del(key)
+}
+
+func (b *versionedBacking) Versions(key s3kv.Key) ([]backing.Version, error) {
+	b.listed++
+	return b.versions[key], nil
+}
+
+func (b *versionedBacking) Stat(key s3kv.Key) (backing.Info, error) {
+	vs := b.versions[key]
+	if len(vs) == 0 || vs[0].Deleted {
+		return backing.Info{}, nil
+	}
+	return backing.Info{Exists: true, Size: vs[0].Size, Version: vs[0].ID}, nil
+}
+
+func (b *versionedBacking) GetVersion(key s3kv.Key, id string) ([]byte, error) {
+	return b.values[id], nil
+}
+
+// unversionedBucket has the methods of a versioned backing but reports versioning as off, like an S3 bucket
+// without versioning enabled.
+type unversionedBucket struct {
+	*versionedBacking
+}
+
+func (b unversionedBucket) Supports(c backing.Capability) bool {
+	return c != backing.Versioning && backing.Supports(b.versionedBacking, c)
+}
+
+var _ = Describe("history", func() {
+	write := func(s *s3kv.Store, values ...string) {
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		for _, v := range values {
+			if v == "" {
+				Expect(s.Del(sid, "k")).To(Succeed())
+			} else {
+				Expect(s.Set(sid, "k", []byte(v))).To(Succeed())
+			}
+		}
+	}
+
+	It("is not available by default on backings without versioning", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "hist", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		_, err = s.History("k")
+		Expect(err).To(MatchError(s3kv.ErrNoHistory))
+	})
+
+	It("emulates a version history and restores old versions", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "hist", Backing: b, History: &s3kv.HistoryArgs{}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		write(s, "one", "two", "")
+		Expect(s.List("")).To(BeEmpty())
+		versions, err := s.History("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(3))
+		Expect(versions[0].Latest).To(BeTrue())
+		Expect(versions[0].Deleted).To(BeTrue())
+		Expect(versions[2].Size).To(BeEquivalentTo(3))
+		Expect(versions[1].LastModified.After(versions[2].LastModified)).To(BeTrue())
+
+		Expect(s.GetVersion("k", versions[2].ID)).To(Equal([]byte("one")))
+		Expect(s.GetVersion("k", versions[0].ID)).To(BeNil())
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Restore(sid, "k", versions[2].ID)).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte("one")))
+		Expect(s.Restore(sid, "k", versions[0].ID)).To(Succeed())
+		Expect(s.Get("k")).To(BeNil())
+		Expect(s.Restore(sid, "k", "00000000000000000001")).To(MatchError(ContainSubstring("does not exist")))
+		Expect(s.Incr("n", 1)).To(BeEquivalentTo(1))
+		s.Unlock(sid)
+		Expect(s.History("k")).To(HaveLen(5))
+		Expect(s.History("n")).To(HaveLen(1))
+	})
+
+	It("prunes emulated versions by count and age", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "hist", Backing: newTaggingBacking(), History: &s3kv.HistoryArgs{MaxVersions: 2}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		write(s, "a", "b", "c")
+		versions, err := s.History("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(2))
+		Expect(s.GetVersion("k", versions[1].ID)).To(Equal([]byte("b")))
+
+		s, err = s3kv.New(s3kv.Args{Namespace: "aged", Backing: newTaggingBacking(), History: &s3kv.HistoryArgs{MaxAge: short}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		write(s, "a", "b")
+		time.Sleep(short * 2)
+		write(s, "c")
+		Expect(s.History("k")).To(HaveLen(1))
+	})
+
+	It("gives every emulated version its own ID", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "hist", Backing: b, History: &s3kv.HistoryArgs{MaxVersions: 100}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		values := make([]string, 50)
+		for i := range values {
+			values[i] = fmt.Sprint(i)
+		}
+		write(s, values...)
+		versions, err := s.History("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(50))
+		Expect(s.GetVersion("k", versions[0].ID)).To(Equal([]byte("49")))
+		Expect(s.GetVersion("k", versions[49].ID)).To(Equal([]byte("0")))
+
+		Expect(b.Set("hist.versions/k/00000000000000000001", []byte{})).To(Succeed())
+		_, err = s.GetVersion("k", "00000000000000000001")
+		Expect(err).To(MatchError(ContainSubstring("is empty")))
+	})
+
+	It("emulates a version history when the backing has versioning turned off", func() {
+		b := newVersionedBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "hist", Backing: unversionedBucket{b}, History: &s3kv.HistoryArgs{}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		write(s, "one", "two")
+		Expect(b.Len()).To(Equal(3))
+		versions, err := s.History("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(2))
+		Expect(versions[0].ID).NotTo(ContainSubstring("@"))
+		Expect(s.GetVersion("k", versions[1].ID)).To(Equal([]byte("one")))
+	})
+
+	It("uses the backing's own versions when it has them", func() {
+		b := newVersionedBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "hist", Backing: b, History: &s3kv.HistoryArgs{}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		write(s, "one", "two")
+		Expect(b.Len()).To(Equal(1))
+		versions, err := s.History("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions).To(HaveLen(2))
+		Expect(s.GetVersion("k", versions[1].ID)).To(Equal([]byte("one")))
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.Restore(sid, "k", versions[1].ID)).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte("one")))
+		Expect(s.History("k")).To(HaveLen(3))
+	})
+})
@@ -0,0 +1,63 @@
+package s3kv
+
+import (
+	"context"
+	"time"
+
+	"github.com/mplewis/s3kv/logging"
+	"github.com/mplewis/s3kv/trace"
+)
+
+// operation tracks the span and log entry for a single store operation.
+type operation struct {
+	store *Store
+	ctx   context.Context
+	span  trace.Span
+	entry logging.Entry
+	start time.Time
+}
+
+// begin starts instrumenting a store operation on the given key and session, either of which may be blank.
+func (s *Store) begin(op string, key string, sid SessionID, attrs ...trace.Attribute) *operation {
+	attrs = append(attrs, trace.String(trace.AttrNamespace, s.namespace))
+	if key != "" {
+		attrs = append(attrs, trace.String(trace.AttrKey, key))
+	}
+	if sid != "" {
+		attrs = append(attrs, trace.String(trace.AttrSession, sid))
+	}
+	ctx, span := s.tracer.Start(context.Background(), "s3kv.Store."+op, attrs...)
+	return &operation{
+		store: s,
+		ctx:   ctx,
+		span:  span,
+		entry: logging.Entry{Op: op, Namespace: s.namespace, Key: key, Session: sid},
+		start: time.Now(),
+	}
+}
+
+// call runs a backing operation in a child span.
+func (o *operation) call(op string, attr trace.Attribute, fn func() error) error {
+	_, span := o.store.tracer.Start(o.ctx, "s3kv.backing."+op, attr)
+	err := fn()
+	trace.Finish(span, err)
+	return err
+}
+
+// end finishes the operation's span and logs it. Successful operations are logged at Debug level and failures at
+// Error level.
+func (o *operation) end(err error) {
+	trace.Finish(o.span, err)
+
+	e := o.entry
+	e.Time = time.Now()
+	e.Duration = e.Time.Sub(o.start)
+	e.Level = logging.Debug
+	e.Message = "store operation"
+	if err != nil {
+		e.Level = logging.Error
+		e.Message = "store operation failed"
+		e.Err = err
+	}
+	o.store.logger.Log(e)
+}
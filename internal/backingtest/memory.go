@@ -0,0 +1,88 @@
+// Package backingtest provides an in-memory backing shared by the tests of the other packages.
+package backingtest
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mplewis/s3kv/backing"
+)
+
+// Memory is an in-memory backing for tests, which records the operations performed on it. It only implements the
+// core Backing operations, so tests can wrap it to add optional ones. It is safe for concurrent use; hold its lock
+// to read or modify Data while other goroutines use it.
+type Memory struct {
+	sync.Mutex
+	Data  map[backing.Key][]byte // The stored values. Missing keys read as nil.
+	calls []string
+}
+
+// NewMemory returns an empty in-memory backing.
+func NewMemory() *Memory {
+	return &Memory{Data: map[backing.Key][]byte{}}
+}
+
+// List lists all keys with the given prefix, sorted.
+func (m *Memory) List(prefix string) ([]backing.Key, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, "list "+prefix)
+	keys := []backing.Key{}
+	for k := range m.Data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Get returns the value for the given key, or nil if it does not exist.
+func (m *Memory) Get(key backing.Key) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, "get "+key)
+	return m.Data[key], nil
+}
+
+// Set sets the value for the given key.
+func (m *Memory) Set(key backing.Key, value []byte) error {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, "set "+key)
+	m.Data[key] = value
+	return nil
+}
+
+// Del deletes the key-value pair for the given key.
+func (m *Memory) Del(key backing.Key) error {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, "del "+key)
+	delete(m.Data, key)
+	return nil
+}
+
+// Record adds an operation to the log, for wrappers which add operations of their own.
+func (m *Memory) Record(call string) {
+	m.Lock()
+	defer m.Unlock()
+	m.calls = append(m.calls, call)
+}
+
+// Calls returns and clears the log of operations performed, such as "get k".
+func (m *Memory) Calls() []string {
+	m.Lock()
+	defer m.Unlock()
+	calls := m.calls
+	m.calls = nil
+	return calls
+}
+
+// Len returns the number of stored values.
+func (m *Memory) Len() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.Data)
+}
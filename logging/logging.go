@@ -0,0 +1,182 @@
+// Package logging defines the structured log entries emitted by s3kv and a few small loggers for them. Adapt your
+// own logging library to Logger, or use Text for logfmt-style output.
+package logging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry.
+type Level int
+
+const (
+	Debug Level = iota // Routine operations, such as every Get and Set.
+	Info               // Notable but expected events.
+	Warn               // Problems that s3kv recovered from, such as lock timeouts and expired sessions.
+	Error              // Operations which failed.
+)
+
+// String returns the lowercase name of the level.
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// Entry is a single structured log entry. Fields which don't apply are left empty.
+type Entry struct {
+	Time      time.Time
+	Level     Level
+	Message   string
+	Op        string        // The operation, such as "Get" or "lock_timed_out".
+	Namespace string        // The store or backing namespace.
+	Key       string        // The key operated on.
+	Keys      []string      // The keys locked or contended.
+	Session   string        // The session ID.
+	Owner     string        // The session owner label.
+	Duration  time.Duration // How long the operation took.
+	Err       error         // The error the operation failed with.
+}
+
+// Logger receives structured log entries.
+type Logger interface {
+	Log(e Entry)
+}
+
+// LoggerFunc adapts a plain function to the Logger interface.
+type LoggerFunc func(e Entry)
+
+// Log calls f(e).
+func (f LoggerFunc) Log(e Entry) {
+	f(e)
+}
+
+// Options configures the logger returned by New.
+type Options struct {
+	Level  Level                   // Entries below this level are dropped. Defaults to Debug.
+	Redact func(key string) string // Optional. Applied to every key before it is logged, such as HashKey or HideKey.
+}
+
+// filtered is a Logger which drops low-level entries and redacts keys before passing entries on.
+type filtered struct {
+	next Logger
+	opts Options
+}
+
+// New wraps a logger so that entries below the configured level are dropped and keys are redacted.
+func New(l Logger, opts Options) Logger {
+	return &filtered{next: l, opts: opts}
+}
+
+// Log filters and redacts the entry, then passes it on.
+func (f *filtered) Log(e Entry) {
+	if e.Level < f.opts.Level {
+		return
+	}
+	if f.opts.Redact != nil {
+		if e.Key != "" {
+			e.Key = f.opts.Redact(e.Key)
+		}
+		if len(e.Keys) > 0 {
+			keys := make([]string, len(e.Keys))
+			for i, k := range e.Keys {
+				keys[i] = f.opts.Redact(k)
+			}
+			e.Keys = keys
+		}
+	}
+	f.next.Log(e)
+}
+
+// HashKey redacts a key by replacing it with a short SHA-256 digest, so entries for the same key can still be
+// correlated without revealing it.
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// HideKey redacts a key entirely.
+func HideKey(string) string {
+	return "[redacted]"
+}
+
+// text is a Logger which writes logfmt-style lines.
+type text struct {
+	access sync.Mutex
+	w      io.Writer
+}
+
+// Text returns a Logger which writes each entry to w as a single logfmt-style line.
+func Text(w io.Writer) Logger {
+	return &text{w: w}
+}
+
+// Log writes the entry as a line.
+func (t *text) Log(e Entry) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	fields := [][2]string{
+		{"time", e.Time.UTC().Format(time.RFC3339Nano)},
+		{"level", e.Level.String()},
+		{"msg", e.Message},
+	}
+	add := func(k, v string) {
+		if v != "" {
+			fields = append(fields, [2]string{k, v})
+		}
+	}
+	add("op", e.Op)
+	add("namespace", e.Namespace)
+	add("key", e.Key)
+	if len(e.Keys) > 0 {
+		keys := make([]string, len(e.Keys))
+		copy(keys, e.Keys)
+		sort.Strings(keys)
+		add("keys", strings.Join(keys, ","))
+	}
+	add("session", e.Session)
+	add("owner", e.Owner)
+	if e.Duration > 0 {
+		add("duration", e.Duration.String())
+	}
+	if e.Err != nil {
+		add("err", e.Err.Error())
+	}
+
+	parts := make([]string, len(fields))
+	for i, f := range fields {
+		parts[i] = f[0] + "=" + quote(f[1])
+	}
+
+	t.access.Lock()
+	defer t.access.Unlock()
+	fmt.Fprintln(t.w, strings.Join(parts, " "))
+}
+
+// quote quotes a logfmt value if it contains spaces, quotes or equals signs.
+func quote(v string) string {
+	if v == "" || strings.ContainsAny(v, " \"=\n\t") {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+// Discard is a Logger which drops every entry.
+var Discard Logger = LoggerFunc(func(Entry) {})
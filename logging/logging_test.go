@@ -0,0 +1,53 @@
+package logging_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mplewis/s3kv/logging"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestLogging(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Logging Suite")
+}
+
+var _ = Describe("logging", func() {
+	It("writes logfmt lines", func() {
+		buf := bytes.Buffer{}
+		l := logging.Text(&buf)
+		l.Log(logging.Entry{
+			Time:      time.Date(2021, 12, 25, 1, 2, 3, 0, time.UTC),
+			Level:     logging.Error,
+			Message:   "store operation failed",
+			Op:        "Set",
+			Namespace: "ns",
+			Key:       "k",
+			Session:   "sid",
+			Duration:  1500 * time.Millisecond,
+			Err:       errors.New("access denied"),
+		})
+		Expect(buf.String()).To(Equal(`time=2021-12-25T01:02:03Z level=error msg="store operation failed" op=Set ` +
+			`namespace=ns key=k session=sid duration=1.5s err="access denied"` + "\n"))
+	})
+
+	It("filters by level and redacts keys", func() {
+		var got []logging.Entry
+		sink := logging.LoggerFunc(func(e logging.Entry) { got = append(got, e) })
+		l := logging.New(sink, logging.Options{Level: logging.Warn, Redact: logging.HideKey})
+
+		l.Log(logging.Entry{Level: logging.Debug, Key: "secret"})
+		l.Log(logging.Entry{Level: logging.Warn, Key: "secret", Keys: []string{"a", "b"}})
+		Expect(got).To(HaveLen(1))
+		Expect(got[0].Key).To(Equal("[redacted]"))
+		Expect(got[0].Keys).To(Equal([]string{"[redacted]", "[redacted]"}))
+
+		Expect(logging.HashKey("secret")).To(Equal(logging.HashKey("secret")))
+		Expect(logging.HashKey("secret")).ToNot(ContainSubstring("secret"))
+		Expect(logging.HashKey("secret")).ToNot(Equal(logging.HashKey("other")))
+	})
+})
@@ -0,0 +1,169 @@
+package s3kv
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/trace"
+)
+
+// metaNamespace is appended to the store's namespace to form the namespace for metadata sidecars, which keeps them
+// out of List results.
+const metaNamespace = ".meta"
+
+// sidecar holds the metadata for a value on backings which can't store it natively.
+type sidecar struct {
+	ETag     string    `json:"etag"`     // The hex MD5 of the stored value this metadata belongs to.
+	Modified time.Time `json:"modified"` // When the value was written.
+	Meta     Meta      `json:"meta"`
+}
+
+// metaKey returns the backing key for the metadata sidecar of the given key.
+func (s *Store) metaKey(key string) string {
+	return s.namespace + metaNamespace + NS_DELIM + key
+}
+
+// etag returns the ETag recorded in a sidecar for the given stored value.
+func etag(stored []byte) string {
+	sum := md5.Sum(stored)
+	return hex.EncodeToString(sum[:])
+}
+
+// SetWithMeta sets the value for the given key along with metadata such as its content type. You must have an open
+// session for the key.
+//
+// On backings which implement backing.MetaWriter, such as S3, the metadata is stored with the value as object
+// headers. Otherwise it is written to a small sidecar object, which Stat ignores once the value is overwritten.
+func (s *Store) SetWithMeta(sid SessionID, key string, value []byte, meta Meta) error {
+	op := s.begin("SetWithMeta", key, sid, trace.Int(trace.AttrBytes, len(value)))
+	err := s.check(sid, key)
+	if err == nil {
+		stored := encodeValue(value)
+		err = s.replace(op, key, func() error {
+			return s.setWithMeta(op, key, stored, meta)
+		})
+		if err == nil {
+			err = s.record(op, key, value)
+		}
+	}
+	op.end(err)
+	return err
+}
+
+// setWithMeta writes a stored value and its metadata, natively if the backing supports it and with a sidecar if not.
+func (s *Store) setWithMeta(op *operation, key string, stored []byte, meta Meta) error {
+	native := true
+	err := op.call("SetWithMeta", trace.String(trace.AttrKey, key), func() error {
+		err := backing.SetWithMeta(s.backing, s.ns1(key), stored, meta)
+		if errors.Is(err, backing.ErrUnsupported) {
+			native = false
+			return nil
+		}
+		return err
+	})
+	if err != nil || native {
+		return err
+	}
+
+	atomic.StoreUint32(&s.sidecars, 1)
+	err = op.call("Set", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Set(s.ns1(key), stored)
+	})
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(sidecar{ETag: etag(stored), Modified: time.Now().UTC(), Meta: meta})
+	if err != nil {
+		return err
+	}
+	return op.call("Set", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Set(s.metaKey(key), data)
+	})
+}
+
+// Stat returns the size, last-modified time, ETag and metadata of the value for the given key without returning the
+// value itself. Info.Exists is false if the key does not exist.
+//
+// On backings which implement backing.Statter, such as S3, this is a single cheap request. Other backings read the
+// value to describe it.
+//
+// Size and ETag describe the object the store keeps for the key, not the value Get returns, so they are for noticing
+// changes rather than sizing buffers. A value with a TTL includes its 12-byte expiry header, a value which Append has
+// split into segments is described by its segment index, and backing middleware such as compression changes both.
+func (s *Store) Stat(key string) (Info, error) {
+	op := s.begin("Stat", key, "")
+	info, err := s.stat(op, key)
+	op.end(err)
+	return info, err
+}
+
+// stat describes the value for the given key, merging in its sidecar metadata if it has any.
+func (s *Store) stat(op *operation, key string) (Info, error) {
+	var info Info
+	statted := true
+	err := op.call("Stat", trace.String(trace.AttrKey, key), func() (err error) {
+		info, err = backing.Stat(s.backing, s.ns1(key))
+		if errors.Is(err, backing.ErrUnsupported) {
+			statted = false
+			return nil
+		}
+		return err
+	})
+	if err != nil || (statted && (!info.Exists || !info.Meta.IsZero())) {
+		return info, err
+	}
+
+	var data []byte
+	err = op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		data, err = s.backing.Get(s.metaKey(key))
+		return err
+	})
+	if err != nil || (data == nil && statted) {
+		return info, err
+	}
+
+	var stored []byte
+	err = op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		stored, err = s.backing.Get(s.ns1(key))
+		return err
+	})
+	if err != nil {
+		return Info{}, err
+	}
+	if _, expired := decodeValue(stored, time.Now()); stored == nil || expired {
+		return Info{}, nil
+	}
+	if !statted {
+		info = Info{Exists: true, Size: int64(len(stored)), ETag: etag(stored)}
+	}
+	if data != nil {
+		sc := sidecar{}
+		if err := json.Unmarshal(data, &sc); err != nil {
+			return Info{}, fmt.Errorf("reading metadata for %s: %w", key, err)
+		}
+		if sc.ETag == etag(stored) {
+			info.Meta = sc.Meta
+			if info.LastModified.IsZero() {
+				info.LastModified = sc.Modified
+			}
+		}
+	}
+	return info, nil
+}
+
+// dropSidecar deletes the metadata sidecar for the given key, if the store's backing can't store metadata natively or
+// the store has fallen back to a sidecar since it was opened.
+func (s *Store) dropSidecar(op *operation, key string) error {
+	if atomic.LoadUint32(&s.sidecars) == 0 {
+		return nil
+	}
+	return op.call("Del", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Del(s.metaKey(key))
+	})
+}
@@ -0,0 +1,168 @@
+package s3kv_test
+
+import (
+	"time"
+
+	"github.com/mplewis/s3kv"
+	"github.com/mplewis/s3kv/backing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// metaBacking stores metadata natively, like S3 object headers.
+type metaBacking struct {
+	*taggingBacking
+	meta map[string]backing.Meta
+}
+
+func (b *metaBacking) SetWithMeta(key s3kv.Key, value []byte, meta backing.Meta) error {
+	b.meta[key] = meta
+	return b.Set(key, value)
+}
+
+func (b *metaBacking) Stat(key s3kv.Key) (backing.Info, error) {
+	value, _ := b.Get(key)
+	if value == nil {
+		return backing.Info{}, nil
+	}
+	return backing.Info{Exists: true, Size: int64(len(value)), ETag: "native", Meta: b.meta[key]}, nil
+}
+
+// claimsMeta implements backing.MetaWriter but can't store metadata, like a wrapper around a plain backing.
+type claimsMeta struct {
+	*taggingBacking
+}
+
+func (b claimsMeta) SetWithMeta(s3kv.Key, []byte, backing.Meta) error {
+	return backing.ErrUnsupported
+}
+
+var _ = Describe("metadata", func() {
+	meta := s3kv.Meta{ContentType: "text/plain", CacheControl: "no-cache", User: map[string]string{"owner": "ops"}}
+
+	It("stores metadata in a sidecar on backings without native support", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "meta", Backing: b})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.SetWithMeta(sid, "k", []byte("hello"), meta)).To(Succeed())
+		Expect(s.Get("k")).To(Equal([]byte("hello")))
+		Expect(s.List("")).To(Equal([]string{"meta/k"}))
+
+		info, err := s.Stat("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Exists).To(BeTrue())
+		Expect(info.Size).To(BeEquivalentTo(5))
+		Expect(info.ETag).NotTo(BeEmpty())
+		Expect(info.LastModified).To(BeTemporally("~", time.Now(), time.Second))
+		Expect(info.Meta).To(Equal(meta))
+
+		Expect(s.Set(sid, "k", []byte("replaced"))).To(Succeed())
+		info, err = s.Stat("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size).To(BeEquivalentTo(8))
+		Expect(info.Meta.IsZero()).To(BeTrue())
+
+		Expect(s.Del(sid, "k")).To(Succeed())
+		Expect(b.Len()).To(BeZero())
+		Expect(s.Stat("k")).To(Equal(s3kv.Info{}))
+	})
+
+	It("uses native metadata when the backing supports it", func() {
+		b := &metaBacking{newTaggingBacking(), map[string]backing.Meta{}}
+		s, err := s3kv.New(s3kv.Args{Namespace: "meta", Backing: b})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.SetWithMeta(sid, "k", []byte("hello"), meta)).To(Succeed())
+		Expect(b.Len()).To(Equal(1))
+
+		info, err := s.Stat("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.ETag).To(Equal("native"))
+		Expect(info.Meta).To(Equal(meta))
+		Expect(s.Stat("missing")).To(Equal(s3kv.Info{}))
+	})
+
+	It("describes the stored object for values with a TTL or segments", func() {
+		b := &metaBacking{newTaggingBacking(), map[string]backing.Meta{}}
+		s, err := s3kv.New(s3kv.Args{Namespace: "meta", Backing: b, AppendSegmentSize: 4})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("ttl", "log")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.SetWithTTL(sid, "ttl", []byte("hello"), time.Hour)).To(Succeed())
+		info, err := s.Stat("ttl")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Size).To(BeEquivalentTo(5 + 12))
+
+		Expect(s.Set(sid, "log", []byte("abc"))).To(Succeed())
+		Expect(s.Append(sid, "log", []byte("defghijk"))).To(Succeed())
+		Expect(s.Get("log")).To(HaveLen(11))
+		info, err = s.Stat("log")
+		Expect(err).NotTo(HaveOccurred())
+		b.Lock()
+		index := b.Data["meta/log"]
+		b.Unlock()
+		Expect(index).To(HavePrefix("S3KS"))
+		Expect(info.Size).To(BeEquivalentTo(len(index)))
+	})
+
+	It("falls back to a sidecar through middleware which can't pass metadata on", func() {
+		b := newTaggingBacking()
+		upper := backing.Intercept(backing.Interceptor{
+			Set: func(key s3kv.Key, value []byte, next func(s3kv.Key, []byte) error) error { return next(key, value) },
+		})
+		s, err := s3kv.New(s3kv.Args{Namespace: "meta", Backing: backing.Chain(b, upper)})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.SetWithMeta(sid, "k", []byte("hello"), meta)).To(Succeed())
+		Expect(b.Len()).To(Equal(2))
+		info, err := s.Stat("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Meta).To(Equal(meta))
+	})
+
+	It("deletes sidecars for chained backings", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "meta", Backing: backing.Chain(b, backing.RetryMiddleware(backing.RetryArgs{}))})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.SetWithMeta(sid, "k", []byte("hello"), meta)).To(Succeed())
+		Expect(b.List("meta.meta/")).To(HaveLen(1))
+		Expect(s.Del(sid, "k")).To(Succeed())
+		Expect(b.Len()).To(BeZero())
+	})
+
+	It("deletes sidecars written by backings which only claim native metadata", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "meta", Backing: claimsMeta{b}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.SetWithMeta(sid, "k", []byte("hello"), meta)).To(Succeed())
+		Expect(b.Len()).To(Equal(2))
+		Expect(s.Del(sid, "k")).To(Succeed())
+		Expect(b.Len()).To(BeZero())
+	})
+})
@@ -0,0 +1,258 @@
+// Package metrics defines the small instrumentation interface used throughout s3kv, and a Registry which
+// implements it and exports the collected values in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Labels are the name-value pairs which distinguish one series of a metric from another.
+type Labels map[string]string
+
+// Metrics receives measurements from instrumented components.
+type Metrics interface {
+	// Count adds delta to a monotonically increasing counter.
+	Count(name string, labels Labels, delta float64)
+	// Gauge adds delta, which may be negative, to a value that can go up and down.
+	Gauge(name string, labels Labels, delta float64)
+	// Observe records a sample, such as a duration in seconds, in a histogram.
+	Observe(name string, labels Labels, value float64)
+}
+
+// DefaultBuckets are the histogram bucket upper bounds used by NewRegistry, suited to durations in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// kind is the type of a metric family.
+type kind string
+
+const (
+	counter   kind = "counter"
+	gauge     kind = "gauge"
+	histogram kind = "histogram"
+)
+
+// series is a single labelled time series within a family.
+type series struct {
+	labels Labels
+	value  float64  // counters and gauges
+	counts []uint64 // histograms: cumulative counts per bucket
+	sum    float64  // histograms
+	count  uint64   // histograms
+}
+
+// family is all the series which share a metric name.
+type family struct {
+	kind   kind
+	series map[string]*series
+}
+
+// Registry collects metrics in memory. It is safe for concurrent use and implements http.Handler, serving its
+// contents in the Prometheus text exposition format.
+type Registry struct {
+	buckets  []float64
+	access   sync.Mutex
+	families map[string]*family
+}
+
+// NewRegistry creates an empty Registry whose histograms use DefaultBuckets.
+func NewRegistry() *Registry {
+	return NewRegistryWithBuckets(DefaultBuckets)
+}
+
+// NewRegistryWithBuckets creates an empty Registry whose histograms use the given bucket upper bounds.
+func NewRegistryWithBuckets(buckets []float64) *Registry {
+	b := make([]float64, len(buckets))
+	copy(b, buckets)
+	sort.Float64s(b)
+	return &Registry{buckets: b, families: map[string]*family{}}
+}
+
+// Count adds delta to a counter. Negative deltas are ignored.
+func (r *Registry) Count(name string, labels Labels, delta float64) {
+	if delta < 0 {
+		return
+	}
+	r.access.Lock()
+	defer r.access.Unlock()
+	if s := r.series(name, counter, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// Gauge adds delta to a gauge.
+func (r *Registry) Gauge(name string, labels Labels, delta float64) {
+	r.access.Lock()
+	defer r.access.Unlock()
+	if s := r.series(name, gauge, labels); s != nil {
+		s.value += delta
+	}
+}
+
+// Observe records a sample in a histogram.
+func (r *Registry) Observe(name string, labels Labels, value float64) {
+	r.access.Lock()
+	defer r.access.Unlock()
+	s := r.series(name, histogram, labels)
+	if s == nil {
+		return
+	}
+	if s.counts == nil {
+		s.counts = make([]uint64, len(r.buckets))
+	}
+	for i, le := range r.buckets {
+		if value <= le {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// Value returns the current value of a counter or gauge series, or the sample count of a histogram series.
+// It returns 0 for series which don't exist. This is mostly useful in tests.
+func (r *Registry) Value(name string, labels Labels) float64 {
+	r.access.Lock()
+	defer r.access.Unlock()
+	f, ok := r.families[name]
+	if !ok {
+		return 0
+	}
+	s, ok := f.series[labelKey(labels)]
+	if !ok {
+		return 0
+	}
+	if f.kind == histogram {
+		return float64(s.count)
+	}
+	return s.value
+}
+
+// series finds or creates the series for a name and labels. It returns nil if the name is already in use by a
+// metric of a different kind; those samples are dropped. The caller must hold r.access.
+func (r *Registry) series(name string, k kind, labels Labels) *series {
+	f, ok := r.families[name]
+	if !ok {
+		f = &family{kind: k, series: map[string]*series{}}
+		r.families[name] = f
+	}
+	if f.kind != k {
+		return nil
+	}
+	key := labelKey(labels)
+	s, ok := f.series[key]
+	if !ok {
+		copied := Labels{}
+		for k, v := range labels {
+			copied[k] = v
+		}
+		s = &series{labels: copied}
+		f.series[key] = s
+	}
+	return s
+}
+
+// WritePrometheus writes every metric in the Prometheus text exposition format, sorted by name and labels.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.access.Lock()
+	defer r.access.Unlock()
+
+	bw := bufio.NewWriter(w)
+	names := make([]string, 0, len(r.families))
+	for name := range r.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := r.families[name]
+		fmt.Fprintf(bw, "# TYPE %s %s\n", name, f.kind)
+		keys := make([]string, 0, len(f.series))
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := f.series[key]
+			if f.kind != histogram {
+				fmt.Fprintf(bw, "%s%s %s\n", name, formatLabels(s.labels, "", 0), formatFloat(s.value))
+				continue
+			}
+			for i, le := range r.buckets {
+				fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(s.labels, "le", le), s.counts[i])
+			}
+			fmt.Fprintf(bw, "%s_bucket%s %d\n", name, formatLabels(s.labels, "le", math.Inf(1)), s.count)
+			fmt.Fprintf(bw, "%s_sum%s %s\n", name, formatLabels(s.labels, "", 0), formatFloat(s.sum))
+			fmt.Fprintf(bw, "%s_count%s %d\n", name, formatLabels(s.labels, "", 0), s.count)
+		}
+	}
+	return bw.Flush()
+}
+
+// ServeHTTP serves the registry's contents in the Prometheus text exposition format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WritePrometheus(w)
+}
+
+// labelKey builds a stable identifier for a set of labels.
+func labelKey(labels Labels) string {
+	return formatLabels(labels, "", 0)
+}
+
+// formatLabels renders labels in exposition format, sorted by name. If extra is set, it is added as a label with
+// the given bound as its value, as used for histogram buckets.
+func formatLabels(labels Labels, extra string, bound float64) string {
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names)+1)
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", name, escape(labels[name])))
+	}
+	if extra != "" {
+		parts = append(parts, fmt.Sprintf("%s=\"%s\"", extra, formatFloat(bound)))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// escape escapes a label value for the exposition format.
+func escape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+// formatFloat renders a sample value for the exposition format.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// Discard is a Metrics which drops every measurement.
+var Discard Metrics = discard{}
+
+// discard implements Discard.
+type discard struct{}
+
+func (discard) Count(string, Labels, float64)   {}
+func (discard) Gauge(string, Labels, float64)   {}
+func (discard) Observe(string, Labels, float64) {}
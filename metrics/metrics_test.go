@@ -0,0 +1,61 @@
+package metrics_test
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mplewis/s3kv/metrics"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics Suite")
+}
+
+var _ = Describe("Registry", func() {
+	It("exports counters, gauges and histograms in Prometheus text format", func() {
+		r := metrics.NewRegistryWithBuckets([]float64{1, 0.1})
+		r.Count("ops_total", metrics.Labels{"op": "get"}, 2)
+		r.Count("ops_total", metrics.Labels{"op": "get"}, 1)
+		r.Count("ops_total", metrics.Labels{"op": "set", "note": "a \"quoted\"\nvalue"}, 1)
+		r.Count("ops_total", nil, -5)
+		r.Gauge("active", nil, 3)
+		r.Gauge("active", nil, -1)
+		r.Observe("wait_seconds", metrics.Labels{"op": "lock"}, 0.05)
+		r.Observe("wait_seconds", metrics.Labels{"op": "lock"}, 0.5)
+		r.Observe("wait_seconds", metrics.Labels{"op": "lock"}, 5)
+		r.Gauge("ops_total", nil, 1) // wrong kind, dropped
+
+		buf := bytes.Buffer{}
+		Expect(r.WritePrometheus(&buf)).To(Succeed())
+		Expect(buf.String()).To(Equal(`# TYPE active gauge
+active 2
+# TYPE ops_total counter
+ops_total{note="a \"quoted\"\nvalue",op="set"} 1
+ops_total{op="get"} 3
+# TYPE wait_seconds histogram
+wait_seconds_bucket{op="lock",le="0.1"} 1
+wait_seconds_bucket{op="lock",le="1"} 2
+wait_seconds_bucket{op="lock",le="+Inf"} 3
+wait_seconds_sum{op="lock"} 5.55
+wait_seconds_count{op="lock"} 3
+`))
+
+		Expect(r.Value("ops_total", metrics.Labels{"op": "get"})).To(Equal(3.0))
+		Expect(r.Value("wait_seconds", metrics.Labels{"op": "lock"})).To(Equal(3.0))
+		Expect(r.Value("missing", nil)).To(Equal(0.0))
+	})
+
+	It("serves metrics over HTTP", func() {
+		r := metrics.NewRegistry()
+		r.Count("hits_total", nil, 1)
+
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		Expect(rec.Header().Get("Content-Type")).To(HavePrefix("text/plain; version=0.0.4"))
+		Expect(rec.Body.String()).To(ContainSubstring("hits_total 1\n"))
+	})
+})
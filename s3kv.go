@@ -43,6 +43,7 @@ package s3kv
 import (
 	"time"
 
+	"github.com/mplewis/s3kv/backing"
 	"github.com/mplewis/s3kv/sloto"
 )
 
@@ -61,3 +62,27 @@ type SessionID = string
 
 // Timeouts is the configuration for the store's sloto locks.
 type Timeouts = sloto.Args
+
+// SessionInfo is a snapshot of an open session, used for debugging and administration.
+type SessionInfo = sloto.SessionInfo
+
+// ErrDeadlock is returned by Store.Acquire when waiting for a key would deadlock with another session.
+var ErrDeadlock = sloto.ErrDeadlock
+
+// LockOptions customizes a single session opened with Store.LockWith.
+type LockOptions = sloto.LockOptions
+
+// Event describes something that happened to a lock or session.
+type Event = sloto.Event
+
+// Observer receives lock lifecycle events from a store.
+type Observer = sloto.Observer
+
+// Meta is metadata stored alongside a value, such as its content type.
+type Meta = backing.Meta
+
+// Info describes a stored value without its contents.
+type Info = backing.Info
+
+// Version describes one version of a value in a store's history.
+type Version = backing.Version
@@ -0,0 +1,127 @@
+package sloto
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDeadlock is returned by Acquire when waiting for a key would complete a cycle of sessions waiting on each
+// other. The session that receives it still holds its existing keys; it should usually Unlock and retry.
+var ErrDeadlock = errors.New("deadlock detected")
+
+// ErrSessionClosed is returned when an operation targets a session which is not open.
+var ErrSessionClosed = errors.New("session is not open")
+
+// Acquire adds the given keys to an already-open session, waiting up to the session's lock timeout for them to be
+// released by other sessions and by higher-priority waiters. Sessions waiting on each other are tracked, and if
+// waiting would deadlock, Acquire fails immediately with ErrDeadlock instead of waiting out the timeout.
+func (s *Sloto) Acquire(sid SessionID, keys ...Key) error {
+	defer s.stopWaiting(sid)
+
+	s.emit(Event{Kind: LockRequested, Session: sid, Keys: keys})
+	start := time.Now()
+	contended := false
+	for {
+		failed, timeout, err := s.tryAcquire(sid, keys...)
+		if errors.Is(err, ErrDeadlock) {
+			s.emit(Event{Kind: LockDeadlocked, Session: sid, Keys: keys})
+		}
+		if err != nil {
+			return err
+		}
+		if len(failed) == 0 {
+			s.emit(Event{Kind: LockAcquired, Session: sid, Keys: keys, Wait: time.Since(start)})
+			return nil
+		}
+		if !contended {
+			contended = true
+			s.emit(Event{Kind: LockContended, Session: sid, Keys: failed})
+		}
+
+		if time.Since(start) > timeout {
+			s.emit(Event{Kind: LockTimedOut, Session: sid, Keys: failed, Wait: time.Since(start)})
+			return fmt.Errorf("timed out locking key: %s", failed[0])
+		}
+
+		s.pause()
+	}
+}
+
+// tryAcquire attempts to add the given keys to a session, returning the keys held by other sessions or reserved by
+// higher-priority waiters, along with the session's lock timeout. If any are held, the session is recorded as
+// waiting on their holders.
+func (s *Sloto) tryAcquire(sid SessionID, keys ...Key) (failed []Key, timeout time.Duration, err error) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	sess, ok := s.sessions[sid]
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: %s", ErrSessionClosed, sid)
+	}
+
+	var holders []SessionID
+	seen := map[SessionID]locked{}
+	for _, key := range keys {
+		holder, present := s.keyLocks[key]
+		if holder == sid || !s.unavailable(key, sess.priority, nil) {
+			continue
+		}
+		failed = append(failed, key)
+		if !present {
+			continue // reserved by a waiter, which is not a session yet
+		}
+		if _, dup := seen[holder]; !dup {
+			seen[holder] = lock
+			holders = append(holders, holder)
+		}
+	}
+
+	if len(failed) > 0 {
+		for _, holder := range holders {
+			if s.waitsOn(holder, sid) {
+				delete(s.waiting, sid)
+				return nil, 0, fmt.Errorf("%w: session %s waiting on key %s held by session %s", ErrDeadlock, sid, failed[0], holder)
+			}
+		}
+		s.waiting[sid] = holders
+		return failed, sess.lockTO, nil
+	}
+
+	for _, key := range keys {
+		if _, present := s.keyLocks[key]; present {
+			continue // already in this session
+		}
+		sess.keys = append(sess.keys, key)
+		s.keyLocks[key] = sid
+	}
+	return nil, sess.lockTO, nil
+}
+
+// waitsOn returns true if session from is waiting, directly or transitively, on session to.
+// The caller must hold s.access.
+func (s *Sloto) waitsOn(from SessionID, to SessionID) bool {
+	visited := map[SessionID]locked{}
+	stack := []SessionID{from}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if cur == to {
+			return true
+		}
+		if _, ok := visited[cur]; ok {
+			continue
+		}
+		visited[cur] = lock
+		stack = append(stack, s.waiting[cur]...)
+	}
+	return false
+}
+
+// stopWaiting removes a session from the wait-for graph.
+func (s *Sloto) stopWaiting(sid SessionID) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	delete(s.waiting, sid)
+}
@@ -0,0 +1,96 @@
+package sloto
+
+import (
+	"sort"
+	"time"
+)
+
+// SessionInfo is a snapshot of an open session, used for debugging and administration.
+type SessionInfo struct {
+	ID       SessionID // The ID of the session.
+	Keys     []Key     // The keys locked by the session.
+	Created  time.Time // When the session was opened.
+	Expires  time.Time // When the session will be closed automatically if it is not unlocked first.
+	Owner    string    // An optional label describing who opened the session.
+	Priority int       // The priority the session was opened with.
+}
+
+// info builds a SessionInfo for the given session.
+func (sess *session) info(sid SessionID) SessionInfo {
+	keys := make([]Key, len(sess.keys))
+	copy(keys, sess.keys)
+	return SessionInfo{
+		ID:       sid,
+		Keys:     keys,
+		Created:  sess.created,
+		Expires:  sess.expires,
+		Owner:    sess.owner,
+		Priority: sess.priority,
+	}
+}
+
+// Sessions returns a snapshot of all open sessions, oldest first.
+func (s *Sloto) Sessions() []SessionInfo {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	infos := make([]SessionInfo, 0, len(s.sessions))
+	for sid, sess := range s.sessions {
+		infos = append(infos, sess.info(sid))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Created.Equal(infos[j].Created) {
+			return infos[i].ID < infos[j].ID
+		}
+		return infos[i].Created.Before(infos[j].Created)
+	})
+	return infos
+}
+
+// Session returns a snapshot of the given session, or false if it is not open.
+func (s *Sloto) Session(sid SessionID) (SessionInfo, bool) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	sess, ok := s.sessions[sid]
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return sess.info(sid), true
+}
+
+// Holder returns the ID of the session which currently holds the lock on the given key, or false if it is unlocked.
+func (s *Sloto) Holder(key Key) (SessionID, bool) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	sid, ok := s.keyLocks[key]
+	return sid, ok
+}
+
+// Label sets the owner label on an open session. Returns false if the session is not open.
+func (s *Sloto) Label(sid SessionID, owner string) bool {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	sess, ok := s.sessions[sid]
+	if !ok {
+		return false
+	}
+	sess.owner = owner
+	return true
+}
+
+// ForceUnlock closes the given session on behalf of an administrator, regardless of who opened it.
+// Returns false if the session was not open.
+func (s *Sloto) ForceUnlock(sid SessionID) bool {
+	s.access.Lock()
+	sess := s.release(sid)
+	s.access.Unlock()
+
+	if sess == nil {
+		return false
+	}
+	s.emit(sess.closed(SessionForced, sid))
+	return true
+}
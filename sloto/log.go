@@ -0,0 +1,52 @@
+package sloto
+
+import "github.com/mplewis/s3kv/logging"
+
+// logObserver turns lock lifecycle events into log entries.
+type logObserver struct {
+	logger    logging.Logger
+	namespace string
+}
+
+// LogObserver returns an Observer which logs lock lifecycle events. Routine events are logged at Debug level;
+// timeouts, deadlocks, forced unlocks and expired sessions are logged at Warn level.
+func LogObserver(l logging.Logger, namespace string) Observer {
+	return &logObserver{logger: l, namespace: namespace}
+}
+
+// Observe logs a single event.
+func (o *logObserver) Observe(e Event) {
+	entry := logging.Entry{
+		Time:      e.Time,
+		Level:     logging.Debug,
+		Op:        e.Kind.String(),
+		Namespace: o.namespace,
+		Keys:      e.Keys,
+		Session:   e.Session,
+		Owner:     e.Owner,
+		Duration:  e.Wait,
+	}
+	switch e.Kind {
+	case LockRequested:
+		entry.Message = "lock requested"
+	case LockAcquired:
+		entry.Message = "lock acquired"
+	case LockContended:
+		entry.Message = "lock contended"
+	case LockTimedOut:
+		entry.Level = logging.Warn
+		entry.Message = "timed out waiting for lock"
+	case LockDeadlocked:
+		entry.Level = logging.Warn
+		entry.Message = "lock would deadlock"
+	case SessionReleased:
+		entry.Message = "session released"
+	case SessionForced:
+		entry.Level = logging.Warn
+		entry.Message = "session force-unlocked"
+	case SessionExpired:
+		entry.Level = logging.Warn
+		entry.Message = "session expired before it was unlocked"
+	}
+	o.logger.Log(entry)
+}
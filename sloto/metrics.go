@@ -0,0 +1,71 @@
+package sloto
+
+import (
+	"sync"
+
+	"github.com/mplewis/s3kv/metrics"
+)
+
+// Metric names recorded by the observer returned from MetricsObserver.
+const (
+	MetricLockWait        = "s3kv_lock_wait_seconds"      // Time spent waiting for locks that were acquired.
+	MetricLockContentions = "s3kv_lock_contentions_total" // Lock attempts which found keys already held.
+	MetricLockTimeouts    = "s3kv_lock_timeouts_total"    // Lock attempts which gave up waiting.
+	MetricLockDeadlocks   = "s3kv_lock_deadlocks_total"   // Acquire calls which failed with ErrDeadlock.
+	MetricSessionsActive  = "s3kv_sessions_active"        // Sessions currently open.
+	MetricSessionsClosed  = "s3kv_sessions_closed_total"  // Sessions closed, labelled by reason: released, forced or expired.
+)
+
+// metricsObserver turns lock lifecycle events into metrics.
+type metricsObserver struct {
+	metrics metrics.Metrics
+	access  sync.Mutex
+	open    map[SessionID]locked
+}
+
+// MetricsObserver returns an Observer which records lock wait times, contention, timeouts, deadlocks, and the
+// number of active, expired and released sessions.
+func MetricsObserver(m metrics.Metrics) Observer {
+	return &metricsObserver{metrics: m, open: map[SessionID]locked{}}
+}
+
+// Observe records the metrics for a single event.
+func (o *metricsObserver) Observe(e Event) {
+	switch e.Kind {
+	case LockAcquired:
+		o.metrics.Observe(MetricLockWait, nil, e.Wait.Seconds())
+		o.access.Lock()
+		_, known := o.open[e.Session]
+		o.open[e.Session] = lock
+		o.access.Unlock()
+		if !known {
+			o.metrics.Gauge(MetricSessionsActive, nil, 1)
+		}
+	case LockContended:
+		o.metrics.Count(MetricLockContentions, nil, 1)
+	case LockTimedOut:
+		o.metrics.Count(MetricLockTimeouts, nil, 1)
+	case LockDeadlocked:
+		o.metrics.Count(MetricLockDeadlocks, nil, 1)
+	case SessionReleased, SessionForced, SessionExpired:
+		o.access.Lock()
+		_, known := o.open[e.Session]
+		delete(o.open, e.Session)
+		o.access.Unlock()
+		if known {
+			o.metrics.Gauge(MetricSessionsActive, nil, -1)
+		}
+		o.metrics.Count(MetricSessionsClosed, metrics.Labels{"reason": closeReason(e.Kind)}, 1)
+	}
+}
+
+// closeReason is the label value for the reason a session was closed.
+func closeReason(k EventKind) string {
+	switch k {
+	case SessionForced:
+		return "forced"
+	case SessionExpired:
+		return "expired"
+	}
+	return "released"
+}
@@ -0,0 +1,111 @@
+package sloto
+
+import "time"
+
+// EventKind describes what happened in a lock lifecycle Event.
+type EventKind int
+
+const (
+	LockRequested   EventKind = iota // A caller asked to lock keys. Keys are the requested keys.
+	LockAcquired                     // Keys were locked. Keys are the keys locked and Wait is how long it took.
+	LockContended                    // An attempt found keys held by others. Keys are the conflicting keys.
+	LockTimedOut                     // A caller gave up waiting. Keys are the conflicting keys and Wait is the time spent.
+	LockDeadlocked                   // Acquire failed with ErrDeadlock. Keys are the requested keys.
+	SessionReleased                  // A session was closed by Unlock.
+	SessionForced                    // A session was closed by ForceUnlock.
+	SessionExpired                   // A session reached its session timeout and was closed automatically.
+)
+
+// String returns a short, stable name for the event kind.
+func (k EventKind) String() string {
+	switch k {
+	case LockRequested:
+		return "lock_requested"
+	case LockAcquired:
+		return "lock_acquired"
+	case LockContended:
+		return "lock_contended"
+	case LockTimedOut:
+		return "lock_timed_out"
+	case LockDeadlocked:
+		return "lock_deadlocked"
+	case SessionReleased:
+		return "session_released"
+	case SessionForced:
+		return "session_forced"
+	case SessionExpired:
+		return "session_expired"
+	}
+	return "unknown"
+}
+
+// Event describes something that happened to a lock or session.
+type Event struct {
+	Kind    EventKind
+	Time    time.Time     // When the event happened.
+	Session SessionID     // The session involved, if one exists yet.
+	Keys    []Key         // The keys involved. See EventKind for their meaning.
+	Owner   string        // The owner label of the session or lock request, if any.
+	Wait    time.Duration // How long the caller waited, for LockAcquired and LockTimedOut.
+}
+
+// Observer receives lock lifecycle events. Observe is called synchronously from the goroutine which caused the
+// event, never while the Sloto's internal lock is held, so it may call back into the Sloto. It should return quickly.
+type Observer interface {
+	Observe(e Event)
+}
+
+// ObserverFunc adapts a plain function to the Observer interface.
+type ObserverFunc func(e Event)
+
+// Observe calls f(e).
+func (f ObserverFunc) Observe(e Event) {
+	f(e)
+}
+
+// Observers combines several observers into one which notifies each in order. Nil observers are skipped.
+func Observers(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+// multiObserver fans events out to several observers.
+type multiObserver []Observer
+
+// Observe passes the event to every observer.
+func (m multiObserver) Observe(e Event) {
+	for _, o := range m {
+		if o != nil {
+			o.Observe(e)
+		}
+	}
+}
+
+// ChannelObserver returns an Observer which sends events to a new channel with the given buffer size. Events are
+// dropped rather than blocking the Sloto when the buffer is full.
+func ChannelObserver(buffer int) (Observer, <-chan Event) {
+	ch := make(chan Event, buffer)
+	return ObserverFunc(func(e Event) {
+		select {
+		case ch <- e:
+		default:
+		}
+	}), ch
+}
+
+// emit sends an event to the observer, if there is one. The caller must not hold s.access.
+func (s *Sloto) emit(e Event) {
+	if s.observer == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	s.observer.Observe(e)
+}
+
+// closed builds the event for a session which has been closed.
+func (sess *session) closed(kind EventKind, sid SessionID) Event {
+	keys := make([]Key, len(sess.keys))
+	copy(keys, sess.keys)
+	return Event{Kind: kind, Session: sid, Keys: keys, Owner: sess.owner}
+}
@@ -0,0 +1,88 @@
+package sloto
+
+import (
+	"fmt"
+	"time"
+)
+
+// LockOptions customizes a single session opened with LockWith. Zero values fall back to the Sloto's defaults.
+type LockOptions struct {
+	LockTimeout    time.Duration // How long to try to lock the keys before giving up. Must not exceed Args.MaxLockTimeout.
+	SessionTimeout time.Duration // How long the session may exist before it is closed. Must not exceed Args.MaxSessionTimeout.
+	Priority       int           // While waiting, the keys are reserved against attempts with a lower priority. Defaults to 0.
+	Owner          string        // An optional label describing who opened the session, reported by Sessions().
+}
+
+// waiter is a pending LockWith call. It reserves the keys it wants against lock attempts with a lower priority, so
+// that high-priority callers are not starved by a steady stream of low-priority ones.
+type waiter struct {
+	priority int
+	keys     map[Key]locked
+}
+
+// defaults returns the options used by calls which don't specify any.
+func (s *Sloto) defaults() LockOptions {
+	return LockOptions{LockTimeout: s.lockTO, SessionTimeout: s.sessTO}
+}
+
+// MaxSessionTimeout returns the longest SessionTimeout which LockWith accepts.
+func (s *Sloto) MaxSessionTimeout() time.Duration {
+	return s.maxSess
+}
+
+// resolve fills in defaults for unset options and validates them against the Sloto's maximums.
+func (s *Sloto) resolve(opts LockOptions) (LockOptions, error) {
+	if opts.LockTimeout < 0 {
+		return opts, fmt.Errorf("lock timeout must not be negative: %s", opts.LockTimeout)
+	}
+	if opts.SessionTimeout < 0 {
+		return opts, fmt.Errorf("session timeout must not be negative: %s", opts.SessionTimeout)
+	}
+	if opts.LockTimeout == 0 {
+		opts.LockTimeout = s.lockTO
+	}
+	if opts.SessionTimeout == 0 {
+		opts.SessionTimeout = s.sessTO
+	}
+	if opts.LockTimeout > s.maxLock {
+		return opts, fmt.Errorf("lock timeout %s exceeds maximum of %s", opts.LockTimeout, s.maxLock)
+	}
+	if opts.SessionTimeout > s.maxSess {
+		return opts, fmt.Errorf("session timeout %s exceeds maximum of %s", opts.SessionTimeout, s.maxSess)
+	}
+	return opts, nil
+}
+
+// reserve registers a waiter for the given keys.
+func (s *Sloto) reserve(priority int, keys ...Key) *waiter {
+	w := &waiter{priority: priority, keys: map[Key]locked{}}
+	for _, key := range keys {
+		w.keys[key] = lock
+	}
+
+	s.access.Lock()
+	defer s.access.Unlock()
+	s.waiters[w] = lock
+	return w
+}
+
+// unreserve removes a waiter registered with reserve.
+func (s *Sloto) unreserve(w *waiter) {
+	s.access.Lock()
+	defer s.access.Unlock()
+	delete(s.waiters, w)
+}
+
+// outranked returns true if some waiter other than self wants the key with a priority higher than the given one.
+// The caller must hold s.access.
+func (s *Sloto) outranked(key Key, priority int, self *waiter) bool {
+	for w := range s.waiters {
+		if w == self || w.priority <= priority {
+			continue
+		}
+		if _, ok := w.keys[key]; ok {
+			return true
+		}
+	}
+	return false
+}
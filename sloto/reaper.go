@@ -0,0 +1,111 @@
+package sloto
+
+import (
+	"container/heap"
+	"time"
+)
+
+// expiry is a pending session timeout, ordered by deadline in an expiryHeap.
+type expiry struct {
+	sid   SessionID
+	at    time.Time
+	index int // position in the heap, or -1 once removed
+}
+
+// expiryHeap is a min-heap of session timeouts, soonest first. It implements heap.Interface.
+type expiryHeap []*expiry
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	e := x.(*expiry)
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	e := old[n-1]
+	old[n-1] = nil
+	e.index = -1
+	*h = old[:n-1]
+	return e
+}
+
+// scheduleUnlock schedules a session to be unlocked after its timeout. The caller must hold s.access.
+func (s *Sloto) scheduleUnlock(sid SessionID, sess *session) {
+	sess.exp = &expiry{sid: sid, at: sess.expires}
+	heap.Push(&s.expiries, sess.exp)
+	if sess.exp.index == 0 {
+		s.wakeReaper()
+	}
+}
+
+// cancelUnlock removes a session's pending timeout. The caller must hold s.access.
+func (s *Sloto) cancelUnlock(sess *session) {
+	if sess.exp != nil && sess.exp.index >= 0 {
+		heap.Remove(&s.expiries, sess.exp.index)
+	}
+}
+
+// wakeReaper nudges the reaper to recompute its next deadline. It never blocks.
+func (s *Sloto) wakeReaper() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// reap closes expired sessions until the Sloto is closed. A single reaper serves every session, so the number
+// of goroutines stays constant no matter how many sessions are opened.
+func (s *Sloto) reap() {
+	for {
+		s.access.Lock()
+		now := time.Now()
+		var expired []Event
+		for len(s.expiries) > 0 && !s.expiries[0].at.After(now) {
+			e := heap.Pop(&s.expiries).(*expiry)
+			if sess := s.release(e.sid); sess != nil {
+				expired = append(expired, sess.closed(SessionExpired, e.sid))
+			}
+		}
+		var next <-chan time.Time
+		var timer *time.Timer
+		if len(s.expiries) > 0 {
+			timer = time.NewTimer(s.expiries[0].at.Sub(now))
+			next = timer.C
+		}
+		s.access.Unlock()
+
+		for _, e := range expired {
+			s.emit(e)
+		}
+
+		select {
+		case <-s.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		case <-s.wake:
+		case <-next:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// Close stops the background reaper. Sessions opened on a closed Sloto no longer expire automatically, so only
+// call this once you are finished with the Sloto. Calling Close more than once is safe.
+func (s *Sloto) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+}
@@ -31,9 +31,30 @@ type Sloto struct {
 	lattIntv time.Duration
 	lockTO   time.Duration
 	sessTO   time.Duration
+	maxLock  time.Duration
+	maxSess  time.Duration
+	observer Observer
 	access   sync.Mutex
-	keyLocks map[Key]locked
-	sessions map[SessionID][]Key
+	keyLocks map[Key]SessionID
+	sessions map[SessionID]*session
+	waiting  map[SessionID][]SessionID // wait-for graph: sessions blocked in Acquire -> the sessions they wait on
+	waiters  map[*waiter]locked        // pending LockWith calls, which reserve their keys by priority
+
+	expiries  expiryHeap
+	wake      chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// session is the state of an open session.
+type session struct {
+	keys     []Key
+	created  time.Time
+	expires  time.Time
+	owner    string
+	priority int
+	lockTO   time.Duration // How long Acquire waits for more keys, from the options the session was opened with.
+	exp      *expiry
 }
 
 // Args is the set of arguments for creating a new Sloto. All are optional.
@@ -41,6 +62,9 @@ type Args struct {
 	LockAttemptInterval time.Duration // Minimum time to wait between lock attempts (jitter is added automatically).
 	LockTimeout         time.Duration // How long we try to lock a given set of keys for a new session before giving up.
 	SessionTimeout      time.Duration // How long we allow a session to exist before unlocking its keys and closing it.
+	MaxLockTimeout      time.Duration // The longest LockTimeout a single LockWith call may request. Defaults to LockTimeout.
+	MaxSessionTimeout   time.Duration // The longest SessionTimeout a single LockWith call may request. Defaults to SessionTimeout.
+	Observer            Observer      // Receives lock lifecycle events, such as sessions expiring without being unlocked.
 }
 
 // Default values for Args values, if unset.
@@ -50,7 +74,8 @@ const (
 	defaultSessionTimeout      = 15 * time.Second
 )
 
-// New creates a new Sloto from the given configuration.
+// New creates a new Sloto from the given configuration. It starts a background goroutine which expires sessions;
+// call Close to stop it when the Sloto is no longer needed.
 func New(args Args) *Sloto {
 	if args.LockAttemptInterval == 0 {
 		args.LockAttemptInterval = defaultLockAttemptInterval
@@ -61,77 +86,206 @@ func New(args Args) *Sloto {
 	if args.SessionTimeout == 0 {
 		args.SessionTimeout = defaultSessionTimeout
 	}
-	return &Sloto{
+	if args.MaxLockTimeout < args.LockTimeout {
+		args.MaxLockTimeout = args.LockTimeout
+	}
+	if args.MaxSessionTimeout < args.SessionTimeout {
+		args.MaxSessionTimeout = args.SessionTimeout
+	}
+	s := &Sloto{
 		lattIntv: args.LockAttemptInterval,
 		lockTO:   args.LockTimeout,
 		sessTO:   args.SessionTimeout,
+		maxLock:  args.MaxLockTimeout,
+		maxSess:  args.MaxSessionTimeout,
+		observer: args.Observer,
 		access:   sync.Mutex{},
-		keyLocks: map[Key]locked{},
-		sessions: map[SessionID][]Key{},
+		keyLocks: map[Key]SessionID{},
+		sessions: map[SessionID]*session{},
+		waiting:  map[SessionID][]SessionID{},
+		waiters:  map[*waiter]locked{},
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	go s.reap()
+	return s
+}
+
+// conflicts returns the keys from the given set which are already locked, or which are reserved by a waiting
+// LockWith call that outranks the given priority. The caller must hold s.access.
+func (s *Sloto) conflicts(priority int, self *waiter, keys ...Key) []Key {
+	var failed []Key
+	for _, key := range keys {
+		if s.unavailable(key, priority, self) {
+			failed = append(failed, key)
+		}
 	}
+	return failed
 }
 
-// scheduleUnlock schedules a session to be unlocked after a timeout.
-func (s *Sloto) scheduleUnlock(sid SessionID) {
-	go func() {
-		<-time.After(s.sessTO)
-		s.Unlock(sid)
-	}()
+// unavailable returns true if the key is locked or reserved by a higher-priority waiter. The caller must hold
+// s.access.
+func (s *Sloto) unavailable(key Key, priority int, self *waiter) bool {
+	if _, present := s.keyLocks[key]; present {
+		return true
+	}
+	return s.outranked(key, priority, self)
 }
 
-// tryLock attempts to create a new session and lock the given keys.
-func (s *Sloto) tryLock(keys ...Key) (sid SessionID, failed *Key) {
+// open creates a new session containing the given keys and locks them. The caller must hold s.access.
+func (s *Sloto) open(opts LockOptions, keys ...Key) SessionID {
+	sid := SessionID(uuid.New().String())
+	now := time.Now()
+	sess := &session{
+		keys:     keys,
+		created:  now,
+		expires:  now.Add(opts.SessionTimeout),
+		owner:    opts.Owner,
+		priority: opts.Priority,
+		lockTO:   opts.LockTimeout,
+	}
+	s.sessions[sid] = sess
+	for _, key := range keys {
+		s.keyLocks[key] = sid
+	}
+	s.scheduleUnlock(sid, sess)
+	return sid
+}
+
+// TryLock attempts to create a new session and lock the given keys without waiting.
+// If any of the keys are already locked, no session is created and the conflicting keys are returned.
+func (s *Sloto) TryLock(keys ...Key) (sid SessionID, failed []Key) {
+	s.emit(Event{Kind: LockRequested, Keys: keys})
+	sid, failed = s.tryLock(s.defaults(), nil, keys...)
+	if len(failed) > 0 {
+		s.emit(Event{Kind: LockContended, Keys: failed})
+	} else {
+		s.emit(Event{Kind: LockAcquired, Session: sid, Keys: keys})
+	}
+	return sid, failed
+}
+
+// tryLock attempts to create a new session with the given options and lock the given keys.
+func (s *Sloto) tryLock(opts LockOptions, self *waiter, keys ...Key) (sid SessionID, failed []Key) {
 	s.access.Lock()
 	defer s.access.Unlock()
 
-	for _, key := range keys {
-		_, present := s.keyLocks[key]
-		if present {
-			return "", &key
-		}
+	failed = s.conflicts(opts.Priority, self, keys...)
+	if len(failed) > 0 {
+		return "", failed
 	}
+	return s.open(opts, keys...), nil
+}
 
-	sid = SessionID(uuid.New().String())
-	s.sessions[sid] = keys
+// LockAvailable creates a new session with whichever of the given keys are not already locked, without waiting.
+// The keys which could not be locked are returned as skipped. If no keys could be locked, no session is created.
+func (s *Sloto) LockAvailable(keys ...Key) (sid SessionID, skipped []Key) {
+	s.emit(Event{Kind: LockRequested, Keys: keys})
+	sid, free, skipped := s.lockAvailable(keys...)
+	if len(skipped) > 0 {
+		s.emit(Event{Kind: LockContended, Keys: skipped})
+	}
+	if len(free) > 0 {
+		s.emit(Event{Kind: LockAcquired, Session: sid, Keys: free})
+	}
+	return sid, skipped
+}
+
+// lockAvailable creates a new session with whichever of the given keys are free.
+func (s *Sloto) lockAvailable(keys ...Key) (sid SessionID, free []Key, skipped []Key) {
+	s.access.Lock()
+	defer s.access.Unlock()
+
+	opts := s.defaults()
+	seen := map[Key]locked{}
 	for _, key := range keys {
-		s.keyLocks[key] = lock
+		if _, dup := seen[key]; dup {
+			continue
+		}
+		seen[key] = lock
+		if s.unavailable(key, opts.Priority, nil) {
+			skipped = append(skipped, key)
+		} else {
+			free = append(free, key)
+		}
+	}
+	if len(free) == 0 {
+		return "", nil, skipped
 	}
-	s.scheduleUnlock(sid)
-	return sid, nil
+	return s.open(opts, free...), free, skipped
 }
 
 // Lock creates a new session and locks the given keys.
 func (s *Sloto) Lock(keys ...Key) (SessionID, error) {
+	return s.LockWith(LockOptions{}, keys...)
+}
+
+// LockWith creates a new session and locks the given keys, using the given options in place of the Sloto's
+// defaults. Options are validated against the Sloto's maximums.
+func (s *Sloto) LockWith(opts LockOptions, keys ...Key) (SessionID, error) {
+	opts, err := s.resolve(opts)
+	if err != nil {
+		return "", err
+	}
+
+	s.emit(Event{Kind: LockRequested, Keys: keys, Owner: opts.Owner})
+	self := s.reserve(opts.Priority, keys...)
+	defer s.unreserve(self)
+
 	start := time.Now()
+	contended := false
 	for {
-		sid, failed := s.tryLock(keys...)
-		if failed == nil {
+		sid, failed := s.tryLock(opts, self, keys...)
+		if len(failed) == 0 {
+			s.emit(Event{Kind: LockAcquired, Session: sid, Keys: keys, Owner: opts.Owner, Wait: time.Since(start)})
 			return sid, nil
 		}
+		if !contended {
+			contended = true
+			s.emit(Event{Kind: LockContended, Keys: failed, Owner: opts.Owner})
+		}
 
-		if time.Since(start) > s.lockTO {
-			return "", fmt.Errorf("timed out locking key: %s", *failed)
+		if time.Since(start) > opts.LockTimeout {
+			s.emit(Event{Kind: LockTimedOut, Keys: failed, Owner: opts.Owner, Wait: time.Since(start)})
+			return "", fmt.Errorf("timed out locking key: %s", failed[0])
 		}
 
-		jitter := float64(s.lattIntv) * rand.Float64() * jitterFrac
-		<-time.After(s.lattIntv + time.Duration(jitter))
+		s.pause()
 	}
 }
 
+// pause waits between lock attempts, with jitter.
+func (s *Sloto) pause() {
+	jitter := float64(s.lattIntv) * rand.Float64() * jitterFrac
+	<-time.After(s.lattIntv + time.Duration(jitter))
+}
+
 // Unlock unlocks the given keys and closes the session.
 func (s *Sloto) Unlock(sid SessionID) {
 	s.access.Lock()
-	defer s.access.Unlock()
+	sess := s.release(sid)
+	s.access.Unlock()
 
-	keys, ok := s.sessions[sid]
+	if sess != nil {
+		s.emit(sess.closed(SessionReleased, sid))
+	}
+}
+
+// release unlocks the keys in the given session and closes it, returning the closed session, or nil if the session
+// was not open. The caller must hold s.access.
+func (s *Sloto) release(sid SessionID) *session {
+	sess, ok := s.sessions[sid]
 	if !ok {
-		return // already unlocked
+		return nil // already unlocked
 	}
 
-	for _, key := range keys {
+	for _, key := range sess.keys {
 		delete(s.keyLocks, key)
 	}
 	delete(s.sessions, sid)
+	delete(s.waiting, sid)
+	s.cancelUnlock(sess)
+	return sess
 }
 
 // Contains returns true if the given key is locked within the given session.
@@ -139,12 +293,12 @@ func (s *Sloto) Contains(sid SessionID, key Key) bool {
 	s.access.Lock()
 	defer s.access.Unlock()
 
-	keys, ok := s.sessions[sid]
+	sess, ok := s.sessions[sid]
 	if !ok {
 		return false
 	}
 
-	for _, k := range keys {
+	for _, k := range sess.keys {
 		if k == key {
 			return true
 		}
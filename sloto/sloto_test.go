@@ -1,7 +1,10 @@
 package sloto_test
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"runtime"
 	"sync"
 	"testing"
 	"time"
@@ -44,6 +47,340 @@ var _ = Describe("Sloto", func() {
 		Expect(err).To(MatchError("timed out locking key: bar"))
 	})
 
+	It("tries to lock without waiting", func() {
+		s := sloto.New(sloto.Args{LockTimeout: 10 * time.Second})
+
+		sid, failed := s.TryLock("foo", "bar")
+		Expect(failed).To(BeEmpty())
+		Expect(sid).ToNot(BeEmpty())
+
+		start := time.Now()
+		sid2, failed := s.TryLock("bar", "baz", "foo")
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		Expect(sid2).To(BeEmpty())
+		Expect(failed).To(Equal([]string{"bar", "foo"}))
+		Expect(s.Contains(sid2, "baz")).To(BeFalse())
+
+		s.Unlock(sid)
+		sid2, failed = s.TryLock("bar", "baz", "foo")
+		Expect(failed).To(BeEmpty())
+		Expect(s.Contains(sid2, "baz")).To(BeTrue())
+	})
+
+	It("locks whichever keys are available", func() {
+		s := sloto.New(sloto.Args{LockTimeout: 10 * time.Second})
+
+		held, err := s.Lock("b", "d")
+		Expect(err).ToNot(HaveOccurred())
+
+		sid, skipped := s.LockAvailable("a", "b", "c", "d", "a")
+		Expect(sid).ToNot(BeEmpty())
+		Expect(skipped).To(Equal([]string{"b", "d"}))
+		Expect(s.Contains(sid, "a")).To(BeTrue())
+		Expect(s.Contains(sid, "c")).To(BeTrue())
+		Expect(s.Contains(sid, "b")).To(BeFalse())
+
+		sid, skipped = s.LockAvailable("a", "b")
+		Expect(sid).To(BeEmpty())
+		Expect(skipped).To(Equal([]string{"a", "b"}))
+
+		s.Unlock(held)
+		_, failed := s.TryLock("b", "d")
+		Expect(failed).To(BeEmpty())
+	})
+
+	It("reports open sessions and their holders", func() {
+		a := sloto.Args{SessionTimeout: time.Minute}
+		s := sloto.New(a)
+
+		before := time.Now()
+		first, err := s.Lock("foo", "bar")
+		Expect(err).ToNot(HaveOccurred())
+		second, err := s.Lock("baz")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Label(second, "sweeper")).To(BeTrue())
+
+		infos := s.Sessions()
+		Expect(infos).To(HaveLen(2))
+		Expect(infos[0].ID).To(Equal(first))
+		Expect(infos[0].Keys).To(Equal([]string{"foo", "bar"}))
+		Expect(infos[0].Created).To(BeTemporally(">=", before))
+		Expect(infos[0].Expires).To(BeTemporally("~", infos[0].Created.Add(a.SessionTimeout)))
+		Expect(infos[0].Owner).To(BeEmpty())
+		Expect(infos[1].ID).To(Equal(second))
+		Expect(infos[1].Owner).To(Equal("sweeper"))
+
+		holder, ok := s.Holder("bar")
+		Expect(ok).To(BeTrue())
+		Expect(holder).To(Equal(first))
+		_, ok = s.Holder("qux")
+		Expect(ok).To(BeFalse())
+
+		Expect(s.ForceUnlock(first)).To(BeTrue())
+		Expect(s.ForceUnlock(first)).To(BeFalse())
+		_, ok = s.Holder("bar")
+		Expect(ok).To(BeFalse())
+		_, ok = s.Session(first)
+		Expect(ok).To(BeFalse())
+		Expect(s.Sessions()).To(HaveLen(1))
+		Expect(s.Label(first, "gone")).To(BeFalse())
+	})
+
+	It("expires sessions without a goroutine per session", func() {
+		s := sloto.New(sloto.Args{SessionTimeout: time.Hour})
+		defer s.Close()
+
+		baseline := runtime.NumGoroutine()
+		for i := 0; i < 10000; i++ {
+			sid, err := s.Lock("k")
+			Expect(err).ToNot(HaveOccurred())
+			s.Unlock(sid)
+		}
+		Expect(runtime.NumGoroutine()).To(BeNumerically("<=", baseline+1))
+	})
+
+	It("expires sessions in deadline order", func() {
+		s := sloto.New(sloto.Args{SessionTimeout: 50 * time.Millisecond})
+		defer s.Close()
+
+		first, err := s.Lock("a")
+		Expect(err).ToNot(HaveOccurred())
+		<-time.After(25 * time.Millisecond)
+		second, err := s.Lock("b")
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(func() bool { return s.Contains(first, "a") }).Should(BeFalse())
+		Expect(s.Contains(second, "b")).To(BeTrue())
+		Eventually(func() bool { return s.Contains(second, "b") }).Should(BeFalse())
+	})
+
+	It("stops expiring sessions once closed", func() {
+		s := sloto.New(sloto.Args{SessionTimeout: 10 * time.Millisecond})
+		s.Close()
+		s.Close()
+
+		sid, err := s.Lock("a")
+		Expect(err).ToNot(HaveOccurred())
+		Consistently(func() bool { return s.Contains(sid, "a") }, 50*time.Millisecond).Should(BeTrue())
+	})
+
+	It("adds keys to an open session", func() {
+		s := sloto.New(sloto.Args{LockAttemptInterval: time.Millisecond, LockTimeout: 20 * time.Millisecond})
+		defer s.Close()
+
+		sid, err := s.Lock("a")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Acquire(sid, "a", "b")).To(Succeed())
+		Expect(s.Contains(sid, "b")).To(BeTrue())
+
+		other, err := s.Lock("c")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(s.Acquire(sid, "c")).To(MatchError("timed out locking key: c"))
+
+		s.Unlock(other)
+		Expect(s.Acquire(sid, "c")).To(Succeed())
+		Expect(s.Contains(sid, "c")).To(BeTrue())
+
+		s.Unlock(sid)
+		Expect(errors.Is(s.Acquire(sid, "d"), sloto.ErrSessionClosed)).To(BeTrue())
+	})
+
+	It("acquires keys with the session's own lock timeout and priority", func() {
+		s := sloto.New(sloto.Args{
+			LockAttemptInterval: time.Millisecond,
+			LockTimeout:         10 * time.Millisecond,
+			MaxLockTimeout:      time.Second,
+		})
+		defer s.Close()
+
+		sid, err := s.LockWith(sloto.LockOptions{LockTimeout: time.Second}, "a")
+		Expect(err).ToNot(HaveOccurred())
+		other, err := s.Lock("b")
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			<-time.After(50 * time.Millisecond)
+			s.Unlock(other)
+		}()
+		Expect(s.Acquire(sid, "b")).To(Succeed())
+
+		held, err := s.Lock("k")
+		Expect(err).ToNot(HaveOccurred())
+		got := make(chan sloto.SessionID)
+		go func() {
+			defer GinkgoRecover()
+			waiter, err := s.LockWith(sloto.LockOptions{Priority: 1, LockTimeout: time.Second}, "k")
+			Expect(err).ToNot(HaveOccurred())
+			got <- waiter
+		}()
+		<-time.After(20 * time.Millisecond)
+
+		// once released, the key is reserved for the higher-priority waiter rather than the acquiring session
+		low, err := s.LockWith(sloto.LockOptions{LockTimeout: 100 * time.Millisecond}, "c")
+		Expect(err).ToNot(HaveOccurred())
+		acquired := make(chan error)
+		go func() { acquired <- s.Acquire(low, "k") }()
+		<-time.After(20 * time.Millisecond)
+		s.Unlock(held)
+
+		var waiter sloto.SessionID
+		Eventually(got).Should(Receive(&waiter))
+		Expect(s.Contains(waiter, "k")).To(BeTrue())
+		Eventually(acquired).Should(Receive(HaveOccurred()))
+		Expect(s.Contains(low, "k")).To(BeFalse())
+	})
+
+	It("detects deadlocks between sessions", func() {
+		s := sloto.New(sloto.Args{LockAttemptInterval: time.Millisecond, LockTimeout: 10 * time.Second})
+		defer s.Close()
+
+		first, err := s.Lock("x")
+		Expect(err).ToNot(HaveOccurred())
+		second, err := s.Lock("y")
+		Expect(err).ToNot(HaveOccurred())
+
+		// first waits on second...
+		firstDone := make(chan error)
+		go func() { firstDone <- s.Acquire(first, "y") }()
+		<-time.After(20 * time.Millisecond)
+
+		// ...so second waiting on first would deadlock
+		start := time.Now()
+		err = s.Acquire(second, "x")
+		Expect(errors.Is(err, sloto.ErrDeadlock)).To(BeTrue())
+		Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+
+		// once the victim gives up its keys, the survivor proceeds
+		s.Unlock(second)
+		Eventually(firstDone).Should(Receive(BeNil()))
+		Expect(s.Contains(first, "x")).To(BeTrue())
+		Expect(s.Contains(first, "y")).To(BeTrue())
+	})
+
+	It("detects deadlocks across longer cycles", func() {
+		s := sloto.New(sloto.Args{LockAttemptInterval: time.Millisecond, LockTimeout: 10 * time.Second})
+		defer s.Close()
+
+		a, _ := s.Lock("a")
+		b, _ := s.Lock("b")
+		c, _ := s.Lock("c")
+
+		go func() { _ = s.Acquire(a, "b") }()
+		go func() { _ = s.Acquire(b, "c") }()
+		<-time.After(20 * time.Millisecond)
+
+		Expect(errors.Is(s.Acquire(c, "a"), sloto.ErrDeadlock)).To(BeTrue())
+		s.Unlock(a)
+		s.Unlock(b)
+		s.Unlock(c)
+	})
+
+	It("accepts per-session options within the maximums", func() {
+		s := sloto.New(sloto.Args{
+			LockAttemptInterval: time.Millisecond,
+			LockTimeout:         10 * time.Millisecond,
+			SessionTimeout:      time.Hour,
+			MaxSessionTimeout:   2 * time.Hour,
+		})
+		defer s.Close()
+
+		_, err := s.LockWith(sloto.LockOptions{SessionTimeout: 3 * time.Hour}, "a")
+		Expect(err).To(MatchError("session timeout 3h0m0s exceeds maximum of 2h0m0s"))
+		_, err = s.LockWith(sloto.LockOptions{LockTimeout: time.Second}, "a")
+		Expect(err).To(MatchError("lock timeout 1s exceeds maximum of 10ms"))
+		_, err = s.LockWith(sloto.LockOptions{LockTimeout: -time.Second}, "a")
+		Expect(err).To(HaveOccurred())
+
+		long, err := s.LockWith(sloto.LockOptions{SessionTimeout: 2 * time.Hour, Owner: "batch", Priority: 3}, "a")
+		Expect(err).ToNot(HaveOccurred())
+		short, err := s.LockWith(sloto.LockOptions{SessionTimeout: 20 * time.Millisecond}, "b")
+		Expect(err).ToNot(HaveOccurred())
+
+		info, ok := s.Session(long)
+		Expect(ok).To(BeTrue())
+		Expect(info.Owner).To(Equal("batch"))
+		Expect(info.Priority).To(Equal(3))
+		Expect(info.Expires).To(BeTemporally("~", info.Created.Add(2*time.Hour)))
+
+		Eventually(func() bool { return s.Contains(short, "b") }).Should(BeFalse())
+		Expect(s.Contains(long, "a")).To(BeTrue())
+	})
+
+	It("reserves contended keys for higher-priority waiters", func() {
+		s := sloto.New(sloto.Args{LockAttemptInterval: time.Millisecond, LockTimeout: time.Second})
+		defer s.Close()
+
+		held, err := s.Lock("k")
+		Expect(err).ToNot(HaveOccurred())
+
+		got := make(chan sloto.SessionID)
+		go func() {
+			defer GinkgoRecover()
+			sid, err := s.LockWith(sloto.LockOptions{Priority: 1}, "k")
+			Expect(err).ToNot(HaveOccurred())
+			got <- sid
+		}()
+		<-time.After(20 * time.Millisecond)
+		s.Unlock(held)
+
+		// the key is free, but reserved for the waiting high-priority caller
+		Consistently(func() []string {
+			_, failed := s.TryLock("k")
+			return failed
+		}, 5*time.Millisecond, time.Millisecond).Should(Equal([]string{"k"}))
+
+		var sid sloto.SessionID
+		Eventually(got).Should(Receive(&sid))
+		Expect(s.Contains(sid, "k")).To(BeTrue())
+	})
+
+	It("reports lock lifecycle events", func() {
+		obs, events := sloto.ChannelObserver(100)
+		s := sloto.New(sloto.Args{
+			LockAttemptInterval: time.Millisecond,
+			LockTimeout:         10 * time.Millisecond,
+			SessionTimeout:      time.Hour,
+			MaxSessionTimeout:   time.Hour,
+			Observer:            obs,
+		})
+		defer s.Close()
+
+		kinds := func() []sloto.EventKind {
+			var got []sloto.EventKind
+			for {
+				select {
+				case e := <-events:
+					got = append(got, e.Kind)
+				default:
+					return got
+				}
+			}
+		}
+
+		sid, err := s.LockWith(sloto.LockOptions{Owner: "me"}, "a")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(kinds()).To(Equal([]sloto.EventKind{sloto.LockRequested, sloto.LockAcquired}))
+
+		_, err = s.Lock("a")
+		Expect(err).To(HaveOccurred())
+		Expect(kinds()).To(Equal([]sloto.EventKind{sloto.LockRequested, sloto.LockContended, sloto.LockTimedOut}))
+
+		s.Unlock(sid)
+		s.Unlock(sid)
+		e := <-events
+		Expect(e.Kind).To(Equal(sloto.SessionReleased))
+		Expect(e.Session).To(Equal(sid))
+		Expect(e.Keys).To(Equal([]string{"a"}))
+		Expect(e.Owner).To(Equal("me"))
+		Expect(kinds()).To(BeEmpty())
+
+		sid, err = s.LockWith(sloto.LockOptions{SessionTimeout: 10 * time.Millisecond}, "b")
+		Expect(err).ToNot(HaveOccurred())
+		kinds()
+		Eventually(events).Should(Receive(&e))
+		Expect(e.Kind).To(Equal(sloto.SessionExpired))
+		Expect(e.Session).To(Equal(sid))
+	})
+
 	It("passes a stress test", func() {
 		s := sloto.New(sloto.Args{
 			LockAttemptInterval: 100 * time.Millisecond,
@@ -108,3 +445,23 @@ var _ = Describe("Sloto", func() {
 		Expect(len(z)).To(Equal(count * 2))
 	})
 })
+
+// BenchmarkShortSessions opens and closes many short sessions. The goroutines metric should stay flat regardless of
+// b.N, since session timeouts are handled by a single reaper.
+func BenchmarkShortSessions(b *testing.B) {
+	s := sloto.New(sloto.Args{SessionTimeout: time.Hour})
+	defer s.Close()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			sid, failed := s.TryLock(fmt.Sprintf("key-%d", i))
+			if len(failed) == 0 {
+				s.Unlock(sid)
+			}
+			i++
+		}
+	})
+	b.ReportMetric(float64(runtime.NumGoroutine()), "goroutines")
+}
@@ -0,0 +1,412 @@
+package s3kv
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/trace"
+)
+
+// ErrNoSnapshot is returned by OpenSnapshot and DeleteSnapshot when the named snapshot does not exist.
+var ErrNoSnapshot = errors.New("snapshot does not exist")
+
+// snapshotNamespace is appended to the store's namespace to form the namespace for snapshot manifests and copies,
+// which keeps them out of List results.
+const snapshotNamespace = ".snapshots"
+
+// snapshotGrace is how long GCSnapshots leaves an incomplete snapshot alone, in case it is still being taken.
+const snapshotGrace = time.Hour
+
+// manifest records the contents of a snapshot.
+type manifest struct {
+	Name     string                   `json:"name"`
+	Created  time.Time                `json:"created"`
+	Complete bool                     `json:"complete"` // False while the snapshot is being taken.
+	Versions bool                     `json:"versions"` // True if entries refer to backing versions rather than copies.
+	Entries  map[string]manifestEntry `json:"entries,omitempty"`
+}
+
+// manifestEntry records a single key in a snapshot.
+type manifestEntry struct {
+	Version string `json:"version,omitempty"` // The backing version ID, if the snapshot uses versions.
+	ETag    string `json:"etag"`
+	Size    int64  `json:"size"`
+}
+
+// SnapshotInfo describes a snapshot.
+type SnapshotInfo struct {
+	Name     string    // The name given to Snapshot.
+	Created  time.Time // When the snapshot was taken.
+	Keys     int       // How many keys the snapshot contains.
+	Complete bool      // False if the snapshot is still being taken, or was interrupted.
+}
+
+// snapshotPrefix returns the backing key prefix for everything belonging to the named snapshot.
+func (s *Store) snapshotPrefix(name string) string {
+	return s.namespace + snapshotNamespace + NS_DELIM + name + NS_DELIM
+}
+
+// manifestKey returns the backing key for the manifest of the named snapshot.
+func (s *Store) manifestKey(name string) string {
+	return s.snapshotPrefix(name) + "manifest"
+}
+
+// snapshotDataKey returns the backing key for the copy of a key's value in the named snapshot.
+func (s *Store) snapshotDataKey(name string, key string) string {
+	return s.snapshotPrefix(name) + "data" + NS_DELIM + key
+}
+
+// readManifest returns the manifest of the named snapshot, or ErrNoSnapshot if it does not exist.
+func (s *Store) readManifest(op *operation, name string) (manifest, error) {
+	var data []byte
+	err := op.call("Get", trace.String(trace.AttrKey, name), func() (err error) {
+		data, err = s.backing.Get(s.manifestKey(name))
+		return err
+	})
+	if err != nil {
+		return manifest{}, err
+	}
+	if data == nil {
+		return manifest{}, fmt.Errorf("%w: %s", ErrNoSnapshot, name)
+	}
+	m := manifest{}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return manifest{}, fmt.Errorf("reading manifest for snapshot %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// writeManifest stores the manifest of a snapshot.
+func (s *Store) writeManifest(op *operation, m manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return op.call("Set", trace.String(trace.AttrKey, m.Name), func() error {
+		return s.backing.Set(s.manifestKey(m.Name), data)
+	})
+}
+
+// Snapshot records the current value of every key in the store under the given name, so it can be read later with
+// OpenSnapshot. Every key is locked while the snapshot is taken, so it is consistent across keys; keys created
+// after it starts are not included.
+//
+// The keys are locked in one session with the longest session timeout the store allows. If any key stays locked by
+// another session for longer than the lock timeout, the snapshot fails, so take snapshots when the store is quiet.
+// If the session times out before every key is recorded, the snapshot fails rather than being marked complete.
+//
+// On backings with native versioning, such as versioned S3 buckets, the snapshot only records version IDs.
+// Otherwise it copies each value, so it takes as much space as the namespace itself.
+func (s *Store) Snapshot(name string) (SnapshotInfo, error) {
+	op := s.begin("Snapshot", "", "", trace.String("s3kv.snapshot", name))
+	m, err := s.snapshot(op, name)
+	op.end(err)
+	if err != nil {
+		return SnapshotInfo{}, err
+	}
+	return m.info(), nil
+}
+
+// snapshot takes a snapshot and returns its manifest.
+func (s *Store) snapshot(op *operation, name string) (manifest, error) {
+	if name == "" || strings.Contains(name, NS_DELIM) {
+		return manifest{}, fmt.Errorf("invalid snapshot name %q", name)
+	}
+	_, err := s.readManifest(op, name)
+	if err == nil {
+		return manifest{}, fmt.Errorf("snapshot %s already exists", name)
+	}
+	if !errors.Is(err, ErrNoSnapshot) {
+		return manifest{}, err
+	}
+
+	var stored []Key
+	err = op.call("List", trace.String(trace.AttrPrefix, ""), func() (err error) {
+		stored, err = s.backing.List(s.ns1(""))
+		return err
+	})
+	if err != nil {
+		return manifest{}, err
+	}
+	keys := make([]string, len(stored))
+	for i, k := range stored {
+		keys[i] = strings.TrimPrefix(k, s.namespace+NS_DELIM)
+	}
+
+	m := manifest{Name: name, Created: time.Now().UTC(), Versions: s.nativeVersions, Entries: map[string]manifestEntry{}}
+	if err := s.writeManifest(op, m); err != nil {
+		return manifest{}, err
+	}
+	var sid SessionID
+	if len(keys) > 0 {
+		opts := LockOptions{Owner: "snapshot " + name, SessionTimeout: s.sloto.MaxSessionTimeout()}
+		sid, err = s.sloto.LockWith(opts, keys...)
+		if err != nil {
+			return manifest{}, err
+		}
+		defer s.sloto.Unlock(sid)
+	}
+	for _, key := range keys {
+		entry, ok, err := s.snapshotKey(op, name, key)
+		if err != nil {
+			return manifest{}, err
+		}
+		if ok {
+			m.Entries[key] = entry
+		}
+	}
+	for _, key := range keys {
+		if !s.sloto.Contains(sid, key) {
+			return manifest{}, fmt.Errorf("snapshot %s lost its lock on %s before it finished", name, key)
+		}
+	}
+	m.Complete = true
+	return m, s.writeManifest(op, m)
+}
+
+// snapshotKey records the current value of a key in a snapshot. It returns false if the key has no current value.
+func (s *Store) snapshotKey(op *operation, name string, key string) (manifestEntry, bool, error) {
+	if s.nativeVersions {
+		return s.currentVersion(op, key)
+	}
+
+	var stored []byte
+	err := op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		stored, err = s.backing.Get(s.ns1(key))
+		return err
+	})
+	if err != nil {
+		return manifestEntry{}, false, err
+	}
+	value, err := s.decode(op, key, stored)
+	if err != nil || value == nil {
+		return manifestEntry{}, false, err
+	}
+	err = op.call("Set", trace.String(trace.AttrKey, key), func() error {
+		return s.backing.Set(s.snapshotDataKey(name, key), value)
+	})
+	return manifestEntry{ETag: etag(value), Size: int64(len(value))}, err == nil, err
+}
+
+// currentVersion returns a snapshot entry for the current backing version of a key. It reads the version ID with a
+// Stat where the backing reports one, such as S3's HEAD, rather than listing every version of the key.
+func (s *Store) currentVersion(op *operation, key string) (manifestEntry, bool, error) {
+	var info backing.Info
+	err := op.call("Stat", trace.String(trace.AttrKey, key), func() (err error) {
+		info, err = backing.Stat(s.backing, s.ns1(key))
+		return err
+	})
+	if err != nil && !errors.Is(err, backing.ErrUnsupported) {
+		return manifestEntry{}, false, err
+	}
+	if err == nil && !info.Exists {
+		return manifestEntry{}, false, nil
+	}
+	if err == nil && info.Version != "" {
+		return manifestEntry{Version: info.Version, ETag: info.ETag, Size: info.Size}, true, nil
+	}
+
+	var versions []Version
+	err = op.call("Versions", trace.String(trace.AttrKey, key), func() (err error) {
+		versions, err = backing.Versions(s.backing, s.ns1(key))
+		return err
+	})
+	if err != nil || len(versions) == 0 || !versions[0].Latest || versions[0].Deleted {
+		return manifestEntry{}, false, err
+	}
+	v := versions[0]
+	return manifestEntry{Version: v.ID, ETag: v.ETag, Size: v.Size}, true, nil
+}
+
+// info describes the snapshot recorded by a manifest.
+func (m manifest) info() SnapshotInfo {
+	return SnapshotInfo{Name: m.Name, Created: m.Created, Keys: len(m.Entries), Complete: m.Complete}
+}
+
+// Snapshots lists the store's snapshots, oldest first.
+func (s *Store) Snapshots() ([]SnapshotInfo, error) {
+	op := s.begin("Snapshots", "", "")
+	infos, err := s.snapshots(op)
+	op.end(err)
+	return infos, err
+}
+
+// snapshots lists the store's snapshots, including incomplete ones.
+func (s *Store) snapshots(op *operation) ([]SnapshotInfo, error) {
+	prefix := s.namespace + snapshotNamespace + NS_DELIM
+	var keys []Key
+	err := op.call("List", trace.String(trace.AttrPrefix, prefix), func() (err error) {
+		keys, err = s.backing.List(prefix)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	var infos []SnapshotInfo
+	for _, k := range keys {
+		name := strings.TrimSuffix(strings.TrimPrefix(k, prefix), NS_DELIM+"manifest")
+		if strings.Contains(name, NS_DELIM) || name+NS_DELIM+"manifest" != strings.TrimPrefix(k, prefix) {
+			continue
+		}
+		m, err := s.readManifest(op, name)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, m.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Created.Before(infos[j].Created) })
+	return infos, nil
+}
+
+// OpenSnapshot returns a read-only view of the named snapshot.
+func (s *Store) OpenSnapshot(name string) (*Snapshot, error) {
+	op := s.begin("OpenSnapshot", "", "", trace.String("s3kv.snapshot", name))
+	m, err := s.readManifest(op, name)
+	if err == nil && !m.Complete {
+		err = fmt.Errorf("snapshot %s is incomplete", name)
+	}
+	op.end(err)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{store: s, manifest: m}, nil
+}
+
+// DeleteSnapshot deletes the named snapshot and any values copied into it. Values in a versioned backing are left
+// alone, since they are still part of its history.
+func (s *Store) DeleteSnapshot(name string) error {
+	op := s.begin("DeleteSnapshot", "", "", trace.String("s3kv.snapshot", name))
+	_, err := s.readManifest(op, name)
+	if err == nil {
+		err = s.deleteSnapshot(op, name)
+	}
+	op.end(err)
+	return err
+}
+
+// deleteSnapshot deletes everything belonging to the named snapshot, manifest last so an interrupted deletion can
+// be retried.
+func (s *Store) deleteSnapshot(op *operation, name string) error {
+	prefix := s.snapshotPrefix(name)
+	var keys []Key
+	err := op.call("List", trace.String(trace.AttrPrefix, prefix), func() (err error) {
+		keys, err = s.backing.List(prefix)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	data := make([]Key, 0, len(keys))
+	for _, k := range keys {
+		if k != s.manifestKey(name) {
+			data = append(data, k)
+		}
+	}
+	err = op.call("DelMany", trace.String(trace.AttrPrefix, prefix), func() error {
+		return backing.DelMany(s.backing, data)
+	})
+	if err != nil {
+		return err
+	}
+	return op.call("Del", trace.String(trace.AttrKey, name), func() error {
+		return s.backing.Del(s.manifestKey(name))
+	})
+}
+
+// GCSnapshots deletes snapshots older than maxAge, along with snapshots which were interrupted while being taken,
+// and returns the names of the snapshots it deleted. A maxAge of zero keeps every complete snapshot.
+func (s *Store) GCSnapshots(maxAge time.Duration) ([]string, error) {
+	op := s.begin("GCSnapshots", "", "")
+	deleted, err := s.gcSnapshots(op, maxAge)
+	op.end(err)
+	return deleted, err
+}
+
+// gcSnapshots deletes expired and interrupted snapshots.
+func (s *Store) gcSnapshots(op *operation, maxAge time.Duration) ([]string, error) {
+	infos, err := s.snapshots(op)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var deleted []string
+	for _, info := range infos {
+		age := now.Sub(info.Created)
+		interrupted := !info.Complete && age > snapshotGrace
+		if interrupted || (info.Complete && maxAge > 0 && age > maxAge) {
+			if err := s.deleteSnapshot(op, info.Name); err != nil {
+				return deleted, err
+			}
+			deleted = append(deleted, info.Name)
+		}
+	}
+	return deleted, nil
+}
+
+// Snapshot is a read-only view of a store as it was when a snapshot was taken.
+type Snapshot struct {
+	store    *Store
+	manifest manifest
+}
+
+// Info describes the snapshot.
+func (sn *Snapshot) Info() SnapshotInfo {
+	return sn.manifest.info()
+}
+
+// Keys returns the keys in the snapshot with the given prefix, sorted.
+func (sn *Snapshot) Keys(prefix string) []string {
+	var keys []string
+	for key := range sn.manifest.Entries {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Get returns the value the given key had when the snapshot was taken, or nil if it did not exist.
+func (sn *Snapshot) Get(key string) ([]byte, error) {
+	s := sn.store
+	op := s.begin("Snapshot.Get", key, "", trace.String("s3kv.snapshot", sn.manifest.Name))
+	value, err := sn.get(op, key)
+	op.end(err)
+	return value, err
+}
+
+// get reads the value of a key in the snapshot.
+func (sn *Snapshot) get(op *operation, key string) ([]byte, error) {
+	s := sn.store
+	entry, ok := sn.manifest.Entries[key]
+	if !ok {
+		return nil, nil
+	}
+	var value []byte
+	err := op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		if sn.manifest.Versions {
+			value, err = backing.GetVersion(s.backing, s.ns1(key), entry.Version)
+		} else {
+			value, err = s.backing.Get(s.snapshotDataKey(sn.manifest.Name, key))
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, fmt.Errorf("value of %s in snapshot %s is missing", key, sn.manifest.Name)
+	}
+	if sn.manifest.Versions {
+		if _, segmented, _ := parseIndex(key, value); segmented {
+			return nil, fmt.Errorf("value of %s in snapshot %s was written by a segmented Append and can't be read on its own", key, sn.manifest.Name)
+		}
+		value, _ = splitExpiry(value)
+	}
+	return value, nil
+}
@@ -0,0 +1,152 @@
+package s3kv_test
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mplewis/s3kv"
+	"github.com/mplewis/s3kv/backing"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("snapshots", func() {
+	set := func(s *s3kv.Store, key, value string) {
+		sid, err := s.Lock(key)
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		if value == "" {
+			Expect(s.Del(sid, key)).To(Succeed())
+		} else {
+			Expect(s.Set(sid, key, []byte(value))).To(Succeed())
+		}
+	}
+
+	It("copies values into a read-only view", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "snap", Backing: b})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		set(s, "a", "1")
+		set(s, "b/c", "2")
+		info, err := s.Snapshot("before")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Keys).To(Equal(2))
+		Expect(info.Complete).To(BeTrue())
+		_, err = s.Snapshot("before")
+		Expect(err).To(MatchError(ContainSubstring("already exists")))
+		_, err = s.Snapshot("bad/name")
+		Expect(err).To(MatchError(ContainSubstring("invalid snapshot name")))
+
+		set(s, "a", "changed")
+		set(s, "b/c", "")
+		set(s, "d", "new")
+		Expect(s.List("")).To(ConsistOf("snap/a", "snap/d"))
+
+		sn, err := s.OpenSnapshot("before")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sn.Keys("")).To(Equal([]string{"a", "b/c"}))
+		Expect(sn.Keys("b/")).To(Equal([]string{"b/c"}))
+		Expect(sn.Get("a")).To(Equal([]byte("1")))
+		Expect(sn.Get("b/c")).To(Equal([]byte("2")))
+		Expect(sn.Get("d")).To(BeNil())
+
+		Expect(s.Snapshots()).To(HaveLen(1))
+		Expect(s.DeleteSnapshot("before")).To(Succeed())
+		Expect(b.Len()).To(Equal(2))
+		_, err = s.OpenSnapshot("before")
+		Expect(errors.Is(err, s3kv.ErrNoSnapshot)).To(BeTrue())
+		Expect(errors.Is(s.DeleteSnapshot("before"), s3kv.ErrNoSnapshot)).To(BeTrue())
+	})
+
+	It("fails instead of completing when its session times out", func() {
+		b := newTaggingBacking()
+		slow := backing.Intercept(backing.Interceptor{
+			Get: func(key s3kv.Key, next func(s3kv.Key) ([]byte, error)) ([]byte, error) {
+				time.Sleep(short)
+				return next(key)
+			},
+		})
+		s, err := s3kv.New(s3kv.Args{
+			Namespace: "snap",
+			Backing:   backing.Chain(b, slow),
+			Timeouts:  &s3kv.Timeouts{SessionTimeout: short / 2},
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		set(s, "a", "1")
+		_, err = s.Snapshot("slow")
+		Expect(err).To(MatchError(ContainSubstring("lost its lock on a")))
+		infos, err := s.Snapshots()
+		Expect(err).NotTo(HaveOccurred())
+		Expect(infos).To(HaveLen(1))
+		Expect(infos[0].Complete).To(BeFalse())
+	})
+
+	It("fails when a key stays locked by another session", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "snap", Backing: newTaggingBacking(), Timeouts: &s3kv.Timeouts{LockTimeout: short}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		set(s, "a", "1")
+		sid, err := s.Lock("a")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		_, err = s.Snapshot("busy")
+		Expect(err).To(MatchError(ContainSubstring("timed out")))
+	})
+
+	It("records version IDs on versioned backings", func() {
+		b := newVersionedBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "snap", Backing: b})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		set(s, "a", "1")
+		_, err = s.Snapshot("v")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Len()).To(Equal(2))
+		Expect(b.listed).To(BeZero())
+		set(s, "a", "2")
+
+		sn, err := s.OpenSnapshot("v")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sn.Get("a")).To(Equal([]byte("1")))
+	})
+
+	It("copies values on backings with versioning turned off", func() {
+		b := newVersionedBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "snap", Backing: unversionedBucket{b}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		set(s, "a", "1")
+		_, err = s.Snapshot("v")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(b.Len()).To(Equal(3))
+		set(s, "a", "2")
+
+		sn, err := s.OpenSnapshot("v")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(sn.Get("a")).To(Equal([]byte("1")))
+	})
+
+	It("garbage collects old snapshots", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "snap", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		set(s, "a", "1")
+		_, err = s.Snapshot("old")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.GCSnapshots(0)).To(BeEmpty())
+		Expect(s.GCSnapshots(long)).To(BeEmpty())
+		time.Sleep(short * 2)
+		_, err = s.Snapshot("new")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.GCSnapshots(short)).To(Equal([]string{"old"}))
+		Expect(s.Snapshots()).To(HaveLen(1))
+	})
+})
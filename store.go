@@ -1,11 +1,17 @@
 package s3kv
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/logging"
+	"github.com/mplewis/s3kv/metrics"
 	"github.com/mplewis/s3kv/sloto"
+	"github.com/mplewis/s3kv/trace"
 )
 
 const GLOBAL_NAMESPACE = "s3kv"
@@ -15,6 +21,17 @@ type Store struct {
 	namespace string
 	backing   backing.Backing
 	sloto     *sloto.Sloto
+	tracer    trace.Tracer
+	logger    logging.Logger
+
+	expiryTags  bool
+	segmentSize int
+	sidecars    uint32 // Nonzero once the store may have written metadata sidecars, so deletes clean them up.
+
+	nativeVersions bool
+	historyArgs    *HistoryArgs
+	done           chan struct{}
+	closeOnce      sync.Once
 }
 
 // Args are the arguments for a new store.
@@ -22,6 +39,17 @@ type Args struct {
 	Namespace string          // Required. The namespace for this store's session and lock keys.
 	Backing   backing.Backing // Required. The backend for this store, where the data lives and is accessed.
 	Timeouts  *sloto.Args     // Optional. The timeout configuration for this store.
+	Observer  Observer        // Optional. Receives lock lifecycle events. Overrides Timeouts.Observer if both are set.
+	Metrics   metrics.Metrics // Optional. Records backing operations and lock behavior, labelled with the namespace.
+	Tracer    trace.Tracer    // Optional. Creates spans for store operations, lock waits and backing calls.
+	Logger    logging.Logger  // Optional. Logs store operations and lock events. Use logging.New to set a level or redact keys.
+
+	SweepInterval time.Duration // Optional. How often to delete expired values in the background. Defaults to never; call Sweep instead.
+	ExpiryTags    bool          // Optional. Tag values written with SetWithTTL with ExpiryTag, for S3 lifecycle rules.
+
+	AppendSegmentSize int // Optional. Split values which Append grows past this many bytes into segments. Defaults to rewriting the whole value.
+
+	History *HistoryArgs // Optional. Keep an emulated version history. Not needed on backings with native versioning, such as versioned S3 buckets.
 }
 
 // New builds a new Store.
@@ -35,45 +63,175 @@ func New(args Args) (*Store, error) {
 	if args.Timeouts == nil {
 		args.Timeouts = &defaultSlotoArgs
 	}
-	sloto := sloto.New(*args.Timeouts)
-	return &Store{
-		namespace: args.Namespace,
-		backing:   args.Backing,
-		sloto:     sloto,
-	}, nil
+	timeouts := *args.Timeouts
+	if args.Observer != nil {
+		timeouts.Observer = args.Observer
+	}
+	var sidecars uint32
+	if !backing.Supports(args.Backing, backing.Metadata) {
+		sidecars = 1
+	}
+	nativeVersions := backing.Supports(args.Backing, backing.Versioning)
+	if args.History != nil {
+		history := *args.History
+		if history.MaxVersions <= 0 {
+			history.MaxVersions = defaultMaxVersions
+		}
+		args.History = &history
+	}
+	if args.Metrics != nil {
+		args.Backing = backing.Instrument(args.Backing, args.Metrics, args.Namespace)
+		timeouts.Observer = sloto.Observers(timeouts.Observer, sloto.MetricsObserver(args.Metrics))
+	}
+	if args.Tracer == nil {
+		args.Tracer = trace.Noop
+	}
+	if args.Logger == nil {
+		args.Logger = logging.Discard
+	} else {
+		timeouts.Observer = sloto.Observers(timeouts.Observer, sloto.LogObserver(args.Logger, args.Namespace))
+	}
+	sloto := sloto.New(timeouts)
+	s := &Store{
+		namespace:   args.Namespace,
+		backing:     args.Backing,
+		sloto:       sloto,
+		tracer:      args.Tracer,
+		logger:      args.Logger,
+		expiryTags:  args.ExpiryTags,
+		segmentSize: args.AppendSegmentSize,
+		sidecars:    sidecars,
+
+		nativeVersions: nativeVersions,
+		historyArgs:    args.History,
+		done:           make(chan struct{}),
+	}
+	if args.SweepInterval > 0 {
+		go s.sweepEvery(args.SweepInterval)
+	}
+	return s, nil
 }
 
 // List lists all keys in the store with the given prefix. This is likely a very slow operation, so use with caution.
 func (s *Store) List(prefix string) ([]Key, error) {
-	return s.backing.List(s.ns1(prefix))
+	op := s.begin("List", "", "", trace.String(trace.AttrPrefix, prefix))
+	var keys []Key
+	err := op.call("List", trace.String(trace.AttrPrefix, prefix), func() (err error) {
+		keys, err = s.backing.List(s.ns1(prefix))
+		return err
+	})
+	op.end(err)
+	return keys, err
 }
 
-// Get returns the value for the given key.
+// Get returns the value for the given key, or nil if it does not exist or has expired.
 func (s *Store) Get(key string) ([]byte, error) {
-	return s.backing.Get(s.ns1(key))
+	op := s.begin("Get", key, "")
+	var value []byte
+	err := op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		value, err = s.backing.Get(s.ns1(key))
+		return err
+	})
+	if err == nil {
+		value, err = s.decode(op, key, value)
+	}
+	op.span.SetAttributes(trace.Int(trace.AttrBytes, len(value)))
+	op.end(err)
+	return value, err
 }
 
 // Set sets the value for the given key. You must have an open session for the key.
 func (s *Store) Set(sid SessionID, key string, value []byte) error {
-	in := s.sloto.Contains(sid, key)
-	if !in {
-		return fmt.Errorf("session %s does not include key %s", sid, key)
+	op := s.begin("Set", key, sid, trace.Int(trace.AttrBytes, len(value)))
+	err := s.check(sid, key)
+	if err == nil {
+		err = s.write(op, key, encodeValue(value), value)
 	}
-	return s.backing.Set(s.ns1(key), value)
+	op.end(err)
+	return err
 }
 
 // Del deletes the key-value pair for the given key.
 func (s *Store) Del(sid SessionID, key string) error {
-	in := s.sloto.Contains(sid, key)
-	if !in {
-		return fmt.Errorf("session %s does not include key %s", sid, key)
+	op := s.begin("Del", key, sid)
+	err := s.check(sid, key)
+	if err == nil {
+		err = s.remove(op, key)
+	}
+	op.end(err)
+	return err
+}
+
+// write stores the given bytes for a key, replacing any segments it had, and records the value in its history.
+func (s *Store) write(op *operation, key string, stored []byte, value []byte) error {
+	err := s.replace(op, key, func() error {
+		return op.call("Set", trace.String(trace.AttrKey, key), func() error {
+			return s.backing.Set(s.ns1(key), stored)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return s.record(op, key, value)
+}
+
+// remove deletes a key along with its segments and metadata sidecar, and records the deletion in its history.
+func (s *Store) remove(op *operation, key string) error {
+	err := s.replace(op, key, func() error {
+		return op.call("Del", trace.String(trace.AttrKey, key), func() error {
+			return s.backing.Del(s.ns1(key))
+		})
+	})
+	if err == nil {
+		err = s.dropSidecar(op, key)
+	}
+	if err != nil {
+		return err
 	}
-	return s.backing.Del(s.ns1(key))
+	return s.record(op, key, nil)
 }
 
 // Lock acquires the given keys for exclusive writing and returns a new session ID.
 func (s *Store) Lock(keys ...string) (SessionID, error) {
-	return s.sloto.Lock(keys...)
+	_, span := s.tracer.Start(context.Background(), "s3kv.Store.Lock", s.lockAttrs(keys)...)
+	sid, err := s.sloto.Lock(keys...)
+	span.SetAttributes(trace.String(trace.AttrSession, sid))
+	trace.Finish(span, err)
+	return sid, err
+}
+
+// LockWith acquires the given keys for exclusive writing using per-session options, such as longer timeouts for
+// batch jobs, and returns a new session ID. Options are validated against the store's maximum timeouts.
+func (s *Store) LockWith(opts LockOptions, keys ...string) (SessionID, error) {
+	attrs := append(s.lockAttrs(keys), trace.String(trace.AttrOwner, opts.Owner))
+	_, span := s.tracer.Start(context.Background(), "s3kv.Store.LockWith", attrs...)
+	sid, err := s.sloto.LockWith(opts, keys...)
+	span.SetAttributes(trace.String(trace.AttrSession, sid))
+	trace.Finish(span, err)
+	return sid, err
+}
+
+// Acquire adds more keys to an open session, waiting for them to be released by other sessions if needed.
+// If waiting would deadlock with another session, it fails fast with ErrDeadlock; the session keeps the keys it
+// already held, so callers typically Unlock and retry.
+func (s *Store) Acquire(sid SessionID, keys ...string) error {
+	attrs := append(s.lockAttrs(keys), trace.String(trace.AttrSession, sid))
+	_, span := s.tracer.Start(context.Background(), "s3kv.Store.Acquire", attrs...)
+	err := s.sloto.Acquire(sid, keys...)
+	trace.Finish(span, err)
+	return err
+}
+
+// TryLock acquires the given keys for exclusive writing without waiting. If any key is already locked,
+// no session is opened and the conflicting keys are returned.
+func (s *Store) TryLock(keys ...string) (SessionID, []Key) {
+	return s.sloto.TryLock(keys...)
+}
+
+// LockAvailable acquires whichever of the given keys are not already locked, without waiting, and returns a
+// new session ID along with the keys that were skipped. If every key was skipped, no session is opened.
+func (s *Store) LockAvailable(keys ...string) (SessionID, []Key) {
+	return s.sloto.LockAvailable(keys...)
 }
 
 // Unlock releases the exclusive write lock on the keys in the session.
@@ -81,6 +239,48 @@ func (s *Store) Unlock(sid SessionID) {
 	s.sloto.Unlock(sid)
 }
 
+// Close stops the store's background session reaper and sweeper. Open sessions no longer expire once the store is
+// closed.
+func (s *Store) Close() {
+	s.closeOnce.Do(func() { close(s.done) })
+	s.sloto.Close()
+}
+
+// Sessions returns a snapshot of all open sessions, oldest first.
+func (s *Store) Sessions() []SessionInfo {
+	return s.sloto.Sessions()
+}
+
+// Holder returns the ID of the session which currently holds the lock on the given key, or false if it is unlocked.
+func (s *Store) Holder(key string) (SessionID, bool) {
+	return s.sloto.Holder(key)
+}
+
+// Label sets an owner label on an open session so it can be identified in Sessions(). Returns false if the
+// session is not open.
+func (s *Store) Label(sid SessionID, owner string) bool {
+	return s.sloto.Label(sid, owner)
+}
+
+// ForceUnlock closes the given session regardless of who opened it. This is an administrative escape hatch for
+// sessions that are stuck; prefer Unlock in normal operation. Returns false if the session was not open.
+func (s *Store) ForceUnlock(sid SessionID) bool {
+	return s.sloto.ForceUnlock(sid)
+}
+
+// check returns an error if the given session does not hold the lock on the given key.
+func (s *Store) check(sid SessionID, key string) error {
+	if !s.sloto.Contains(sid, key) {
+		return fmt.Errorf("session %s does not include key %s", sid, key)
+	}
+	return nil
+}
+
+// lockAttrs returns the span attributes for a lock operation. Lock waits are logged by the sloto observer.
+func (s *Store) lockAttrs(keys []string) []trace.Attribute {
+	return []trace.Attribute{trace.Strings(trace.AttrKeys, keys), trace.String(trace.AttrNamespace, s.namespace)}
+}
+
 func (s *Store) ns1(key string) string {
 	return s.namespace + NS_DELIM + key
 }
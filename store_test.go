@@ -6,6 +6,11 @@ import (
 	"time"
 
 	"github.com/mplewis/s3kv"
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/logging"
+	"github.com/mplewis/s3kv/metrics"
+	"github.com/mplewis/s3kv/sloto"
+	"github.com/mplewis/s3kv/trace"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -66,6 +71,181 @@ var _ = Describe("store", func() {
 		Expect(err.Error()).To(ContainSubstring("does not include key"))
 	})
 
+	It("skips contended keys without waiting", func() {
+		s, err := s3kv.New(s3kv.Args{
+			Namespace: "test",
+			Backing:   mb,
+			Timeouts:  &s3kv.Timeouts{LockTimeout: long, SessionTimeout: long},
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		held, err := s.Lock("busy")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(held)
+
+		sid, conflicts := s.TryLock("free", "busy")
+		Expect(sid).To(BeEmpty())
+		Expect(conflicts).To(Equal([]string{"busy"}))
+
+		sid, skipped := s.LockAvailable("free", "busy")
+		Expect(skipped).To(Equal([]string{"busy"}))
+		Expect(s.Set(sid, "free", []byte("swept"))).To(Succeed())
+		Expect(s.Set(sid, "busy", []byte("swept")).Error()).To(ContainSubstring("does not include key"))
+		s.Unlock(sid)
+	})
+
+	It("records metrics for backing operations and locks", func() {
+		m := metrics.NewRegistry()
+		s, err := s3kv.New(s3kv.Args{
+			Namespace: "metered",
+			Backing:   mb,
+			Timeouts:  &s3kv.Timeouts{LockTimeout: short, SessionTimeout: long},
+			Metrics:   m,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(m.Value(sloto.MetricSessionsActive, nil)).To(Equal(1.0))
+		Expect(s.Set(sid, "k", []byte("hello"))).To(Succeed())
+		_, err = s.Lock("k")
+		Expect(err).To(HaveOccurred())
+		s.Unlock(sid)
+
+		_, err = s.Get("k")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(m.Value(backing.MetricOperations, metrics.Labels{"backing": "metered", "op": "set", "result": "ok"})).To(Equal(1.0))
+		Expect(m.Value(backing.MetricOperations, metrics.Labels{"backing": "metered", "op": "get", "result": "ok"})).To(Equal(1.0))
+		Expect(m.Value(backing.MetricBytes, metrics.Labels{"backing": "metered", "direction": "write"})).To(Equal(5.0))
+		Expect(m.Value(backing.MetricBytes, metrics.Labels{"backing": "metered", "direction": "read"})).To(Equal(5.0))
+		Expect(m.Value(sloto.MetricLockTimeouts, nil)).To(Equal(1.0))
+		Expect(m.Value(sloto.MetricLockWait, nil)).To(Equal(1.0))
+		Expect(m.Value(sloto.MetricSessionsActive, nil)).To(Equal(0.0))
+		Expect(m.Value(sloto.MetricSessionsClosed, metrics.Labels{"reason": "released"})).To(Equal(1.0))
+	})
+
+	It("traces store operations and their backing calls", func() {
+		rec := trace.NewRecorder()
+		s, err := s3kv.New(s3kv.Args{
+			Namespace: "traced",
+			Backing:   mb,
+			Timeouts:  &s3kv.Timeouts{LockTimeout: short, SessionTimeout: long},
+			Tracer:    rec,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Set(sid, "k", []byte("hello"))).To(Succeed())
+		Expect(s.Del(sid, "other")).NotTo(Succeed())
+		s.Unlock(sid)
+
+		spans := rec.Spans()
+		Expect(spans).To(HaveLen(4))
+
+		Expect(spans[0].Name).To(Equal("s3kv.Store.Lock"))
+		Expect(spans[0].Attributes).To(HaveKeyWithValue(trace.AttrKeys, []string{"k"}))
+		Expect(spans[0].Attributes).To(HaveKeyWithValue(trace.AttrSession, sid))
+
+		Expect(spans[1].Name).To(Equal("s3kv.Store.Set"))
+		Expect(spans[1].Attributes).To(HaveKeyWithValue(trace.AttrNamespace, "traced"))
+		Expect(spans[2].Name).To(Equal("s3kv.backing.Set"))
+		Expect(spans[2].ParentID).To(Equal(spans[1].ID))
+		Expect(spans[2].Err).To(BeNil())
+
+		Expect(spans[3].Name).To(Equal("s3kv.Store.Del"))
+		Expect(spans[3].Err).To(MatchError(ContainSubstring("does not include key")))
+	})
+
+	It("logs operations and expired sessions", func() {
+		entries := make(chan logging.Entry, 100)
+		l := logging.LoggerFunc(func(e logging.Entry) { entries <- e })
+		s, err := s3kv.New(s3kv.Args{
+			Namespace: "logged",
+			Backing:   mb,
+			Timeouts:  &s3kv.Timeouts{LockTimeout: short, SessionTimeout: short},
+			Logger:    logging.New(l, logging.Options{Level: logging.Warn, Redact: logging.HideKey}),
+		})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Set(sid, "k", []byte("v"))).To(Succeed())
+		Expect(s.Set(sid, "nope", []byte("v"))).NotTo(Succeed())
+
+		var e logging.Entry
+		Expect(entries).To(Receive(&e))
+		Expect(e.Level).To(Equal(logging.Error))
+		Expect(e.Op).To(Equal("Set"))
+		Expect(e.Key).To(Equal("[redacted]"))
+		Expect(e.Session).To(Equal(sid))
+		Expect(e.Namespace).To(Equal("logged"))
+
+		Eventually(entries).Should(Receive(&e))
+		Expect(e.Level).To(Equal(logging.Warn))
+		Expect(e.Op).To(Equal("session_expired"))
+		Expect(e.Session).To(Equal(sid))
+	})
+
+	It("cuts over to a new backing with dual writes and a migration", func() {
+		old, next := newTaggingBacking(), newTaggingBacking()
+		before, err := s3kv.New(s3kv.Args{Namespace: "cut", Backing: old})
+		Expect(err).NotTo(HaveOccurred())
+		sid, err := before.Lock("legacy")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(before.Set(sid, "legacy", []byte("old"))).To(Succeed())
+		before.Unlock(sid)
+		before.Close()
+
+		s, err := s3kv.New(s3kv.Args{Namespace: "cut", Backing: backing.DualWrite(old, next, backing.DualWriteArgs{}), AppendSegmentSize: 4})
+		Expect(err).NotTo(HaveOccurred())
+		sid, err = s.Lock("a", "m", "log")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Set(sid, "a", []byte("1"))).To(Succeed())
+		Expect(s.SetWithMeta(sid, "m", []byte("2"), s3kv.Meta{ContentType: "text/plain"})).To(Succeed())
+		Expect(s.Append(sid, "log", []byte("0123456789"))).To(Succeed())
+		Expect(s.Append(sid, "log", []byte("ab"))).To(Succeed())
+		Expect(s.Del(sid, "a")).To(Succeed())
+		s.Unlock(sid)
+		s.Close()
+
+		p, err := backing.Migrate(old, next, backing.MigrateArgs{Verify: true})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(p.Copied).To(Equal(1))
+		Expect(next.Data).To(Equal(old.Data))
+
+		after, err := s3kv.New(s3kv.Args{Namespace: "cut", Backing: next, AppendSegmentSize: 4})
+		Expect(err).NotTo(HaveOccurred())
+		defer after.Close()
+		Expect(after.Get("legacy")).To(Equal([]byte("old")))
+		Expect(after.Get("log")).To(Equal([]byte("0123456789ab")))
+		Expect(after.Get("a")).To(BeNil())
+		info, err := after.Stat("m")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Meta.ContentType).To(Equal("text/plain"))
+	})
+
+	It("tags values with their expiry on both sides of a dual write", func() {
+		old, next := newTaggingBacking(), newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "cut", Backing: backing.DualWrite(old, next, backing.DualWriteArgs{}), ExpiryTags: true})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(s.SetWithTTL(sid, "k", []byte("v"), 36*time.Hour)).To(Succeed())
+		for _, b := range []*taggingBacking{old, next} {
+			b.Lock()
+			Expect(b.tags).To(HaveKeyWithValue("cut/k", map[string]string{s3kv.ExpiryTag: "2"}))
+			b.Unlock()
+		}
+	})
+
 	It("passes a stress test", func() {
 		s, err := s3kv.New(s3kv.Args{
 			Namespace: "test",
@@ -0,0 +1,185 @@
+// Package trace defines a small tracing interface used by s3kv, so spans can be exported to OpenTelemetry or any
+// other tracing system without s3kv depending on it. Adapt your tracer to Tracer and pass it to s3kv.Args.
+package trace
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Attribute names set on s3kv spans.
+const (
+	AttrNamespace = "s3kv.namespace" // The store namespace.
+	AttrKey       = "s3kv.key"       // The key being read or written.
+	AttrKeys      = "s3kv.keys"      // The keys being locked.
+	AttrPrefix    = "s3kv.prefix"    // The prefix being listed.
+	AttrSession   = "s3kv.session"   // The session ID.
+	AttrOwner     = "s3kv.owner"     // The session owner label.
+	AttrBytes     = "s3kv.bytes"     // The size of the value read or written.
+)
+
+// Attribute is a key-value pair attached to a span. Values are strings, ints, bools or string slices.
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// String builds a string attribute.
+func String(key string, value string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Int builds an int attribute.
+func Int(key string, value int) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Strings builds a string slice attribute.
+func Strings(key string, value []string) Attribute {
+	return Attribute{Key: key, Value: value}
+}
+
+// Tracer starts spans. Implementations should return a context carrying the new span, so that spans started from
+// it become its children.
+type Tracer interface {
+	Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span)
+}
+
+// Span is a single timed operation.
+type Span interface {
+	// SetAttributes adds attributes to the span.
+	SetAttributes(attrs ...Attribute)
+	// RecordError marks the span as failed with the given error.
+	RecordError(err error)
+	// End completes the span.
+	End()
+}
+
+// Finish records err on the span, if it is not nil, and ends the span.
+func Finish(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
+
+// Noop is a Tracer whose spans do nothing.
+var Noop Tracer = noopTracer{}
+
+// noopTracer implements Noop.
+type noopTracer struct{}
+
+// Start returns the context unchanged and a span which does nothing.
+func (noopTracer) Start(ctx context.Context, _ string, _ ...Attribute) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+// noopSpan is a Span which does nothing.
+type noopSpan struct{}
+
+func (noopSpan) SetAttributes(...Attribute) {}
+func (noopSpan) RecordError(error)          {}
+func (noopSpan) End()                       {}
+
+// RecordedSpan is a completed or in-progress span captured by a Recorder.
+type RecordedSpan struct {
+	ID         int
+	ParentID   int // 0 for root spans
+	Name       string
+	Attributes map[string]interface{}
+	Err        error
+	Start      time.Time
+	End        time.Time // zero until the span ends
+}
+
+// Recorder is a Tracer which keeps every span in memory, for tests and debugging.
+type Recorder struct {
+	access sync.Mutex
+	spans  []*RecordedSpan
+}
+
+// recorderKey is the context key under which a Recorder stores the current span's ID.
+type recorderKey struct{}
+
+// NewRecorder creates an empty Recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// Start records a new span, parented to the span in ctx if there is one.
+func (r *Recorder) Start(ctx context.Context, name string, attrs ...Attribute) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	parent, _ := ctx.Value(recorderKey{}).(int)
+
+	r.access.Lock()
+	defer r.access.Unlock()
+	rs := &RecordedSpan{
+		ID:         len(r.spans) + 1,
+		ParentID:   parent,
+		Name:       name,
+		Attributes: map[string]interface{}{},
+		Start:      time.Now(),
+	}
+	for _, a := range attrs {
+		rs.Attributes[a.Key] = a.Value
+	}
+	r.spans = append(r.spans, rs)
+	return context.WithValue(ctx, recorderKey{}, rs.ID), &recordingSpan{recorder: r, span: rs}
+}
+
+// Spans returns a copy of every span recorded so far, in the order they were started.
+func (r *Recorder) Spans() []RecordedSpan {
+	r.access.Lock()
+	defer r.access.Unlock()
+	spans := make([]RecordedSpan, 0, len(r.spans))
+	for _, s := range r.spans {
+		c := *s
+		c.Attributes = map[string]interface{}{}
+		for k, v := range s.Attributes {
+			c.Attributes[k] = v
+		}
+		spans = append(spans, c)
+	}
+	return spans
+}
+
+// Reset discards every recorded span.
+func (r *Recorder) Reset() {
+	r.access.Lock()
+	defer r.access.Unlock()
+	r.spans = nil
+}
+
+// recordingSpan is the Span handed out by a Recorder.
+type recordingSpan struct {
+	recorder *Recorder
+	span     *RecordedSpan
+}
+
+// SetAttributes adds attributes to the recorded span.
+func (s *recordingSpan) SetAttributes(attrs ...Attribute) {
+	s.recorder.access.Lock()
+	defer s.recorder.access.Unlock()
+	for _, a := range attrs {
+		s.span.Attributes[a.Key] = a.Value
+	}
+}
+
+// RecordError sets the recorded span's error.
+func (s *recordingSpan) RecordError(err error) {
+	s.recorder.access.Lock()
+	defer s.recorder.access.Unlock()
+	s.span.Err = err
+}
+
+// End sets the recorded span's end time.
+func (s *recordingSpan) End() {
+	s.recorder.access.Lock()
+	defer s.recorder.access.Unlock()
+	if s.span.End.IsZero() {
+		s.span.End = time.Now()
+	}
+}
@@ -0,0 +1,48 @@
+package trace_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/mplewis/s3kv/trace"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestTrace(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Trace Suite")
+}
+
+var _ = Describe("Recorder", func() {
+	It("records nested spans with attributes and errors", func() {
+		rec := trace.NewRecorder()
+		ctx, root := rec.Start(context.Background(), "root", trace.String("a", "b"))
+		_, child := rec.Start(ctx, "child")
+		child.SetAttributes(trace.Int("n", 1))
+		trace.Finish(child, errors.New("failed"))
+		trace.Finish(root, nil)
+
+		spans := rec.Spans()
+		Expect(spans).To(HaveLen(2))
+		Expect(spans[0].Name).To(Equal("root"))
+		Expect(spans[0].ParentID).To(BeZero())
+		Expect(spans[0].Attributes).To(Equal(map[string]interface{}{"a": "b"}))
+		Expect(spans[0].Err).To(BeNil())
+		Expect(spans[0].End).ToNot(BeZero())
+		Expect(spans[1].ParentID).To(Equal(spans[0].ID))
+		Expect(spans[1].Attributes).To(Equal(map[string]interface{}{"n": 1}))
+		Expect(spans[1].Err).To(MatchError("failed"))
+
+		rec.Reset()
+		Expect(rec.Spans()).To(BeEmpty())
+	})
+
+	It("does nothing with the no-op tracer", func() {
+		ctx := context.Background()
+		got, span := trace.Noop.Start(ctx, "anything")
+		Expect(got).To(Equal(ctx))
+		trace.Finish(span, errors.New("ignored"))
+	})
+})
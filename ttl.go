@@ -0,0 +1,167 @@
+package s3kv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mplewis/s3kv/backing"
+	"github.com/mplewis/s3kv/trace"
+)
+
+// ExpiryTag is the object tag set on values written with SetWithTTL when Args.ExpiryTags is enabled. Its value is
+// the TTL in whole days, rounded up, so an S3 lifecycle rule filtering on each value you use can delete expired
+// objects even if no sweeper runs.
+const ExpiryTag = "s3kv-expires-days"
+
+// ttlMagic marks a value which was stored with an expiry. It is followed by the expiry as big-endian Unix
+// nanoseconds, where zero means the value never expires.
+var ttlMagic = []byte("S3KT")
+
+// ttlHeaderLen is the length of the header on a value stored with an expiry.
+var ttlHeaderLen = len(ttlMagic) + 8
+
+// withExpiry returns the stored form of a value which expires at the given time. A zero time never expires.
+func withExpiry(value []byte, expires time.Time) []byte {
+	stored := make([]byte, ttlHeaderLen, ttlHeaderLen+len(value))
+	copy(stored, ttlMagic)
+	if !expires.IsZero() {
+		binary.BigEndian.PutUint64(stored[len(ttlMagic):], uint64(expires.UnixNano()))
+	}
+	return append(stored, value...)
+}
+
+// encodeValue returns the stored form of a value written without a TTL. Most values are stored as-is, but a value
+// which happens to begin with one of the store's headers is given an expiry header of its own so it reads back
+// unchanged.
+func encodeValue(value []byte) []byte {
+	if bytes.HasPrefix(value, ttlMagic) || bytes.HasPrefix(value, segMagic) {
+		return withExpiry(value, time.Time{})
+	}
+	return value
+}
+
+// splitExpiry returns the value stored in the given bytes and when it expires, which is zero if it never does.
+func splitExpiry(stored []byte) ([]byte, time.Time) {
+	if len(stored) < ttlHeaderLen || !bytes.HasPrefix(stored, ttlMagic) {
+		return stored, time.Time{}
+	}
+	nanos := binary.BigEndian.Uint64(stored[len(ttlMagic):ttlHeaderLen])
+	if nanos == 0 {
+		return stored[ttlHeaderLen:], time.Time{}
+	}
+	return stored[ttlHeaderLen:], time.Unix(0, int64(nanos))
+}
+
+// decodeValue returns the value stored in the given bytes and whether it has expired as of now.
+func decodeValue(stored []byte, now time.Time) ([]byte, bool) {
+	value, expires := splitExpiry(stored)
+	if !expires.IsZero() && !now.Before(expires) {
+		return nil, true
+	}
+	return value, false
+}
+
+// expiryDays returns the value of ExpiryTag for the given TTL.
+func expiryDays(ttl time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(ttl.Hours() / 24)))
+}
+
+// SetWithTTL sets the value for the given key so that it reads as not found once the TTL has elapsed. Expired values
+// are deleted by Sweep, or in the background if Args.SweepInterval is set. You must have an open session for the key.
+func (s *Store) SetWithTTL(sid SessionID, key string, value []byte, ttl time.Duration) error {
+	op := s.begin("SetWithTTL", key, sid, trace.Int(trace.AttrBytes, len(value)))
+	err := s.check(sid, key)
+	if err == nil && ttl <= 0 {
+		err = fmt.Errorf("ttl must be positive, got %s", ttl)
+	}
+	if err == nil {
+		err = s.replace(op, key, func() error {
+			return op.call("Set", trace.String(trace.AttrKey, key), func() error {
+				return s.backing.Set(s.ns1(key), withExpiry(value, time.Now().Add(ttl)))
+			})
+		})
+	}
+	if err == nil && s.expiryTags {
+		err = op.call("Tag", trace.String(trace.AttrKey, key), func() error {
+			err := backing.Tag(s.backing, s.ns1(key), map[string]string{ExpiryTag: expiryDays(ttl)})
+			if errors.Is(err, backing.ErrUnsupported) {
+				return nil
+			}
+			return err
+		})
+	}
+	if err == nil {
+		err = s.record(op, key, value)
+	}
+	op.end(err)
+	return err
+}
+
+// Sweep deletes the expired values with the given prefix and returns how many were deleted. Keys which are locked
+// by an open session are skipped, since their holder may be about to overwrite them.
+func (s *Store) Sweep(prefix string) (int, error) {
+	op := s.begin("Sweep", "", "", trace.String(trace.AttrPrefix, prefix))
+	swept := 0
+	var keys []Key
+	err := op.call("List", trace.String(trace.AttrPrefix, prefix), func() (err error) {
+		keys, err = s.backing.List(s.ns1(prefix))
+		return err
+	})
+	for _, stored := range keys {
+		if err != nil {
+			break
+		}
+		key := strings.TrimPrefix(stored, s.namespace+NS_DELIM)
+		sid, conflicts := s.sloto.TryLock(key)
+		if len(conflicts) > 0 {
+			continue
+		}
+		var deleted bool
+		deleted, err = s.sweep(op, key)
+		s.sloto.Unlock(sid)
+		if deleted {
+			swept++
+		}
+	}
+	op.span.SetAttributes(trace.Int("s3kv.swept", swept))
+	op.end(err)
+	return swept, err
+}
+
+// sweep deletes the given key if its value has expired. The caller must hold the lock on the key.
+func (s *Store) sweep(op *operation, key string) (bool, error) {
+	var value []byte
+	err := op.call("Get", trace.String(trace.AttrKey, key), func() (err error) {
+		value, err = s.backing.Get(s.ns1(key))
+		return err
+	})
+	if err != nil || value == nil {
+		return false, err
+	}
+	if _, expired := decodeValue(value, time.Now()); !expired {
+		return false, nil
+	}
+	err = s.remove(op, key)
+	return err == nil, err
+}
+
+// sweepEvery sweeps the whole namespace at the given interval until the store is closed. Failed sweeps are logged
+// like any other store operation.
+func (s *Store) sweepEvery(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.Sweep("")
+		}
+	}
+}
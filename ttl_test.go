@@ -0,0 +1,118 @@
+package s3kv_test
+
+import (
+	"time"
+
+	"github.com/mplewis/s3kv"
+	"github.com/mplewis/s3kv/internal/backingtest"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// taggingBacking is an in-memory backing which records object tags.
+type taggingBacking struct {
+	*backingtest.Memory
+	tags map[string]map[string]string
+}
+
+func newTaggingBacking() *taggingBacking {
+	return &taggingBacking{Memory: backingtest.NewMemory(), tags: map[string]map[string]string{}}
+}
+
+func (b *taggingBacking) Tag(key s3kv.Key, tags map[string]string) error {
+	b.Lock()
+	defer b.Unlock()
+	b.tags[key] = tags
+	return nil
+}
+
+var _ = Describe("TTL", func() {
+	It("reads expired values as not found and sweeps them", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "ttl", Backing: b})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("brief", "lasting", "plain")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.SetWithTTL(sid, "brief", []byte("token"), short)).To(Succeed())
+		Expect(s.SetWithTTL(sid, "lasting", []byte("token"), time.Hour)).To(Succeed())
+		Expect(s.Set(sid, "plain", []byte("value"))).To(Succeed())
+		Expect(s.SetWithTTL(sid, "brief", []byte("token"), 0)).To(MatchError(ContainSubstring("ttl must be positive")))
+		s.Unlock(sid)
+
+		Expect(s.Get("brief")).To(Equal([]byte("token")))
+		time.Sleep(short * 2)
+		Expect(s.Get("brief")).To(BeNil())
+		Expect(s.Get("lasting")).To(Equal([]byte("token")))
+		Expect(s.Get("plain")).To(Equal([]byte("value")))
+		Expect(b.tags).To(BeEmpty())
+
+		Expect(s.Sweep("")).To(Equal(1))
+		Expect(s.List("")).To(ConsistOf("ttl/lasting", "ttl/plain"))
+	})
+
+	It("skips locked keys when sweeping", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "ttl", Backing: b})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.SetWithTTL(sid, "k", []byte("v"), time.Nanosecond)).To(Succeed())
+		Expect(s.Sweep("")).To(Equal(0))
+		s.Unlock(sid)
+		Expect(s.Sweep("")).To(Equal(1))
+	})
+
+	It("removes the metadata and records the deletion of swept values", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "ttl", Backing: b, History: &s3kv.HistoryArgs{}})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.SetWithMeta(sid, "k", []byte("v"), s3kv.Meta{ContentType: "text/plain"})).To(Succeed())
+		Expect(s.SetWithTTL(sid, "k", []byte("v"), time.Nanosecond)).To(Succeed())
+		s.Unlock(sid)
+
+		Expect(s.Sweep("")).To(Equal(1))
+		Expect(b.List("ttl.meta/")).To(BeEmpty())
+		versions, err := s.History("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(versions[0].Deleted).To(BeTrue())
+	})
+
+	It("stores values which look like they have a TTL unchanged", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "ttl", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		tricky := []byte("S3KT\x00\x00\x00\x00\x00\x00\x00\x01payload")
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.Set(sid, "k", tricky)).To(Succeed())
+		s.Unlock(sid)
+		Expect(s.Get("k")).To(Equal(tricky))
+	})
+
+	It("sweeps in the background and tags values for lifecycle rules", func() {
+		b := newTaggingBacking()
+		s, err := s3kv.New(s3kv.Args{Namespace: "ttl", Backing: b, SweepInterval: short, ExpiryTags: true})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+
+		sid, err := s.Lock("k")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(s.SetWithTTL(sid, "k", []byte("v"), 36*time.Hour)).To(Succeed())
+		Expect(s.SetWithTTL(sid, "k", []byte("v"), short)).To(Succeed())
+		s.Unlock(sid)
+
+		b.Lock()
+		Expect(b.tags).To(HaveKeyWithValue("ttl/k", map[string]string{s3kv.ExpiryTag: "1"}))
+		b.Unlock()
+		Eventually(b.Len, long).Should(BeZero())
+	})
+})
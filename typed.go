@@ -0,0 +1,57 @@
+package s3kv
+
+import (
+	"time"
+
+	"github.com/mplewis/s3kv/codec"
+)
+
+// TypedStore reads and writes Go values in a store using a codec, so callers don't marshal values by hand. Each value
+// is stored with the codec's content type, and reading a value written with a different codec returns an error
+// wrapping codec.ErrMismatch.
+type TypedStore struct {
+	store *Store
+	codec codec.Codec
+}
+
+// Typed returns a view of this store which encodes values with the given codec.
+func (s *Store) Typed(c codec.Codec) *TypedStore {
+	return &TypedStore{store: s, codec: c}
+}
+
+// Store returns the underlying store, for locking and other untyped operations.
+func (t *TypedStore) Store() *Store {
+	return t.store
+}
+
+// GetInto decodes the value for the given key into v, which must be a pointer. It returns false, leaving v untouched,
+// if the key does not exist.
+func (t *TypedStore) GetInto(key string, v interface{}) (bool, error) {
+	data, err := t.store.Get(key)
+	if err != nil || data == nil {
+		return false, err
+	}
+	if err := codec.Decode(t.codec, data, v); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetFrom encodes v and stores it for the given key. You must have an open session for the key.
+func (t *TypedStore) SetFrom(sid SessionID, key string, v interface{}) error {
+	data, err := codec.Encode(t.codec, v)
+	if err != nil {
+		return err
+	}
+	return t.store.Set(sid, key, data)
+}
+
+// SetFromWithTTL encodes v and stores it for the given key until the TTL elapses. You must have an open session for
+// the key.
+func (t *TypedStore) SetFromWithTTL(sid SessionID, key string, v interface{}, ttl time.Duration) error {
+	data, err := codec.Encode(t.codec, v)
+	if err != nil {
+		return err
+	}
+	return t.store.SetWithTTL(sid, key, data, ttl)
+}
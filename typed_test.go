@@ -0,0 +1,35 @@
+package s3kv_test
+
+import (
+	"errors"
+
+	"github.com/mplewis/s3kv"
+	"github.com/mplewis/s3kv/codec"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("typed store", func() {
+	type point struct{ X, Y int }
+
+	It("encodes and decodes values with a codec", func() {
+		s, err := s3kv.New(s3kv.Args{Namespace: "typed", Backing: newTaggingBacking()})
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Close()
+		t := s.Typed(codec.JSON)
+
+		sid, err := t.Store().Lock("p", "g")
+		Expect(err).NotTo(HaveOccurred())
+		defer s.Unlock(sid)
+		Expect(t.SetFrom(sid, "p", point{1, 2})).To(Succeed())
+
+		p := point{}
+		Expect(t.GetInto("p", &p)).To(BeTrue())
+		Expect(p).To(Equal(point{1, 2}))
+		Expect(t.GetInto("missing", &p)).To(BeFalse())
+
+		Expect(s.Typed(codec.Gob).SetFrom(sid, "g", point{3, 4})).To(Succeed())
+		_, err = t.GetInto("g", &p)
+		Expect(errors.Is(err, codec.ErrMismatch)).To(BeTrue())
+	})
+})